@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/NYTimes/openapi2proto/protobuf"
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// checkProto compares the generated declaration with the existing file
+// `fn`, after copying the custom regions of the file into it, as writing
+// it would. The differences are written to `dst` as a unified diff, and
+// the returned boolean is false if there are any
+func checkProto(dst io.Writer, fn string, generated []byte, ignoreTrailingWhitespace bool) (bool, error) {
+	existing, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to read %s`, fn)
+	}
+	generated, err = protobuf.KeepCustomRegions(generated, existing)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to keep custom regions of %s`, fn)
+	}
+	diff, err := diffProto(fn, string(existing), string(generated), ignoreTrailingWhitespace)
+	if err != nil {
+		return false, errors.Wrap(err, `failed to compare the generated declaration`)
+	}
+	if diff == "" {
+		return true, nil
+	}
+	fmt.Fprint(dst, diff)
+	return false, nil
+}
+
+// diffProto returns a unified diff between the existing and the generated
+// declarations, or an empty string if they are the same
+func diffProto(filename, existing, generated string, ignoreTrailingWhitespace bool) (string, error) {
+	a := difflib.SplitLines(existing)
+	b := difflib.SplitLines(generated)
+	if ignoreTrailingWhitespace {
+		for _, lines := range [][]string{a, b} {
+			for i, line := range lines {
+				lines[i] = strings.TrimRight(line, " \t\r\n") + "\n"
+			}
+		}
+	}
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: filename,
+		ToFile:   "generated",
+		Context:  3,
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const checkedProto = `syntax = "proto3";
+
+package pets;
+
+message Pet {
+    string name = 1;
+}
+`
+
+func writeExisting(t *testing.T, content string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "openapi2proto-check")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	fn := filepath.Join(dir, "pets.proto")
+	if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to write %s: %s", fn, err)
+	}
+	return fn, func() { os.RemoveAll(dir) }
+}
+
+func TestCheckProto(t *testing.T) {
+	t.Run("up to date", func(t *testing.T) {
+		fn, cleanup := writeExisting(t, checkedProto)
+		defer cleanup()
+
+		var diff bytes.Buffer
+		upToDate, err := checkProto(&diff, fn, []byte(checkedProto), false)
+		if err != nil {
+			t.Fatalf("failed to check: %s", err)
+		}
+		if !upToDate || diff.Len() > 0 {
+			t.Errorf("expected no differences, got:\n%s", diff.String())
+		}
+	})
+
+	t.Run("out of date", func(t *testing.T) {
+		fn, cleanup := writeExisting(t, checkedProto)
+		defer cleanup()
+
+		generated := strings.Replace(checkedProto, "string name = 1;", "string name = 1;\n    int32 age = 2;", 1)
+		var diff bytes.Buffer
+		upToDate, err := checkProto(&diff, fn, []byte(generated), false)
+		if err != nil {
+			t.Fatalf("failed to check: %s", err)
+		}
+		if upToDate {
+			t.Errorf("expected differences to be found")
+		}
+		for _, want := range []string{"--- " + fn, "+++ generated", "+    int32 age = 2;"} {
+			if !strings.Contains(diff.String(), want) {
+				t.Errorf("expected diff to contain %q, got:\n%s", want, diff.String())
+			}
+		}
+	})
+
+	t.Run("trailing whitespace", func(t *testing.T) {
+		fn, cleanup := writeExisting(t, strings.Replace(checkedProto, "package pets;", "package pets;  ", 1))
+		defer cleanup()
+
+		var diff bytes.Buffer
+		upToDate, err := checkProto(&diff, fn, []byte(checkedProto), false)
+		if err != nil {
+			t.Fatalf("failed to check: %s", err)
+		}
+		if upToDate || !strings.Contains(diff.String(), "-package pets;  ") {
+			t.Errorf("expected trailing whitespace to be reported, got:\n%s", diff.String())
+		}
+
+		diff.Reset()
+		upToDate, err = checkProto(&diff, fn, []byte(checkedProto), true)
+		if err != nil {
+			t.Fatalf("failed to check: %s", err)
+		}
+		if !upToDate || diff.Len() > 0 {
+			t.Errorf("expected trailing whitespace to be ignored, got:\n%s", diff.String())
+		}
+	})
+}
@@ -2,17 +2,32 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 
-	"github.com/NYTimes/openapi2proto"
 	"github.com/NYTimes/openapi2proto/compiler"
+	"github.com/NYTimes/openapi2proto/openapi"
 	"github.com/NYTimes/openapi2proto/protobuf"
 	"github.com/pkg/errors"
 )
 
+// stringList is a flag.Value that accumulates the values of a flag
+// that may be specified multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
 func main() {
 	if err := _main(); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %s", err)
@@ -24,35 +39,108 @@ func _main() error {
 	specPath := flag.String("spec", "../../spec.yaml", "location of the swagger spec file")
 	annotate := flag.Bool("annotate", false, "include (google.api.http) options for grpc-gateway. Defaults to false if not set")
 	outfile := flag.String("out", "", "the file to output the result to. Defaults to stdout if not set")
+	defaultsOut := flag.String("defaults-out", "", "the file to write the default values of the fields, as a JSON object keyed by message and field name, e.g. Pet.status. Not written if not set")
+	outdir := flag.String("out-dir", "", "the directory to output the result to, using one file per top level type plus service.proto. Takes precedence over -out")
 	indent := flag.Int("indent", 4, "number of spaces used for indentation")
+	rpcOrder := flag.String("rpc-order", "name", "the order of the rpcs of the service, either name or declaration. Defaults to name if not set")
+	blankLines := flag.Int("blank-lines", 1, "number of blank lines between top level declarations")
 	skipRpcs := flag.Bool("skip-rpcs", false, "skip rpc code generation. Defaults to false if not set")
+	messagesOnly := flag.Bool("messages-only", false, "only generate the messages and enums of the spec, without a service or the annotations import. Defaults to false if not set")
 	skipDeprecatedRpcs := flag.Bool("skip-deprecated-rpcs", false, "skip rpc code generation for endpoints marked as deprecated. Defaults to false if not set")
 	namespaceEnums := flag.Bool("namespace-enums", false, "prefix enum values with the enum name to prevent namespace conflicts. Defaults to false if not set")
 	wrapPrimitives := flag.Bool("wrap-primitives", false, "specify primitive values using their wrapper message types instead of their scalar types. Defaults to false if not set")
+	httpComment := flag.Bool("http-comment", false, "start the comment of each rpc with the HTTP method and path of the endpoint. Defaults to false if not set")
+	tagsAsComment := flag.Bool("tags-as-comment", false, "list the tags of each operation in the comment of the generated rpc. Defaults to false if not set")
+	tagsAsOption := flag.String("tags-as-option", "", "name of a custom rpc option used to carry the comma separated tags of each operation. Disabled if not set")
+	examplesAsComments := flag.Bool("examples-as-comments", false, "add the example of the successful response of each endpoint to the comment of the rpc. Defaults to false if not set")
+	securityAsComment := flag.Bool("security-as-comment", false, "list the security requirements of each operation in the comment of the generated rpc. Defaults to false if not set")
+	securityAsOption := flag.String("security-as-option", "", "name of a custom rpc option used to carry the security requirements of each operation. Disabled if not set")
+	emptyType := flag.String("empty-type", "", "the message used for rpcs without parameters or response bodies, instead of google.protobuf.Empty. Use -import to import the file that declares it")
+	concreteEmptyMessages := flag.Bool("concrete-empty-messages", false, "use empty request and response messages instead of google.protobuf.Empty for rpcs without parameters or response bodies. Defaults to false if not set")
+	splitReadWriteOnly := flag.Bool("split-read-write-only", false, "leave readOnly properties out of requests and writeOnly properties out of responses, generating FooRequest variants of definitions as needed. Defaults to false if not set")
+	serviceName := flag.String("service-name", "", "the name of the generated service. Defaults to the title of the spec followed by Service if not set")
+	requestSuffix := flag.String("request-suffix", "Request", "the suffix appended to the name of an rpc to name its request message")
+	responseSuffix := flag.String("response-suffix", "Response", "the suffix appended to the name of an rpc to name its response messages")
+	errorResponses := flag.Bool("error-responses", false, "generate messages for the inline schemas of responses other than 2xx, named after the description of the response. Defaults to false if not set")
+	additionalPropertiesComment := flag.Bool("additional-properties-comment", false, "note in the comment of messages for objects with additionalProperties: false that additional properties are not allowed. Defaults to false if not set")
+	jsonNameOption := flag.Bool("json-name-option", false, "add a json_name option with the original property name to fields whose names had to be changed. Defaults to false if not set")
+	pruneUnusedMessages := flag.Bool("prune-unused-messages", false, "leave out messages and enums that are not used by any of the generated rpcs. Defaults to false if not set")
+	wellKnownTypes := flag.Bool("well-known-types", false, "use google.protobuf.Timestamp for strings with format date-time, and google.protobuf.Duration for strings with format duration. Defaults to false if not set")
+	emptyObjectAsStruct := flag.Bool("empty-object-as-struct", false, "use google.protobuf.Struct for objects without properties or additionalProperties, instead of empty messages. Defaults to false if not set")
+	topLevelEnums := flag.Bool("top-level-enums", false, "declare all enums in the package instead of in the messages that use them. Defaults to false if not set")
+	fieldNumberBase := flag.Int("field-number-base", 1, "the number that automatically assigned field numbers start from. Defaults to 1 if not set")
+	defaultIntegerType := flag.String("default-integer-type", "int32", "the type of integers without a format, either int32 or int64. Defaults to int32 if not set")
+	useSchemaTitle := flag.Bool("use-schema-title", false, "name messages and enums after the title of their schema, when present. Defaults to false if not set")
+	singularize := flag.Bool("singularize", false, "name the messages and enums for the items of arrays using the singular form of the property name, e.g. Address for addresses. Defaults to false if not set")
+	syntax := flag.String("syntax", "proto3", "the Protocol Buffers syntax to generate, either proto3 or proto2. Defaults to proto3 if not set")
+	validate := flag.Bool("validate", false, "only check the spec for problems that would make the generated declaration invalid, and report them without generating anything. Defaults to false if not set")
+	check := flag.Bool("check", false, "compare the generated declaration against the file given by -out instead of writing it, and report the differences as a unified diff. Defaults to false if not set")
+	ignoreTrailingWhitespace := flag.Bool("ignore-trailing-whitespace", false, "ignore differences in trailing whitespace when using -check. Defaults to false if not set")
+	generatedHeader := flag.Bool("generated-header", false, "start the generated protos with a \"Code generated by openapi2proto from <spec>. DO NOT EDIT.\" comment, naming the spec as given by -spec. Defaults to false if not set")
+	generatedHeaderVersion := flag.String("generated-header-version", "", "the version of openapi2proto to mention in the header added by -generated-header, e.g. v1.2.0. Left out if not set")
 	addAutogeneratedComment := flag.Bool("add-autogenerated-comment", false, "add comment on top of the generated protos that those files are autogenerated and should not be modified. Defaults to false if not set")
+	var extraImports stringList
+	flag.Var(&extraImports, "import", "additional file to import in the generated declaration, e.g. for custom options. May be specified multiple times")
+	var includeTags, excludeTags stringList
+	flag.Var(&includeTags, "include-tag", "only generate rpcs for endpoints with this tag. May be specified multiple times")
+	flag.Var(&excludeTags, "exclude-tag", "skip generating rpcs for endpoints with this tag. May be specified multiple times")
+	var excludeDefinitions stringList
+	flag.Var(&excludeDefinitions, "exclude-def", "skip compiling the definition with this name. May be specified multiple times")
+	var pruneRoots stringList
+	flag.Var(&pruneRoots, "prune-root", "keep the message or enum with this name, and anything it uses, when pruning unused messages. May be specified multiple times")
 	flag.Parse()
 
-	var dst io.Writer = os.Stdout
-	if *outfile != "" {
-		f, err := os.Create(*outfile)
-		if err != nil {
-			return errors.Wrapf(err, `failed to open output file (%v)`, outfile)
-		}
-		defer f.Close()
-		dst = f
+	if *check && (*outfile == "" || *outdir != "") {
+		return errors.New(`-check requires -out, and can not be used with -out-dir`)
 	}
 
-	var options []openapi2proto.Option
 	var encoderOptions []protobuf.Option
 	var compilerOptions []compiler.Option
 
 	compilerOptions = append(compilerOptions, compiler.WithAnnotation(*annotate))
 	compilerOptions = append(compilerOptions, compiler.WithSkipRpcs(*skipRpcs))
 	compilerOptions = append(compilerOptions, compiler.WithSkipDeprecatedRpcs(*skipDeprecatedRpcs))
+	compilerOptions = append(compilerOptions, compiler.WithMessagesOnly(*messagesOnly))
 	compilerOptions = append(compilerOptions, compiler.WithPrefixEnums(*namespaceEnums))
 	compilerOptions = append(compilerOptions, compiler.WithWrapPrimitives(*wrapPrimitives))
+	compilerOptions = append(compilerOptions, compiler.WithExtraImports(extraImports))
+	compilerOptions = append(compilerOptions, compiler.WithHTTPCommentOnRPC(*httpComment))
+	compilerOptions = append(compilerOptions, compiler.WithTagsAsComment(*tagsAsComment))
+	compilerOptions = append(compilerOptions, compiler.WithTagsAsOption(*tagsAsOption))
+	compilerOptions = append(compilerOptions, compiler.WithExamplesAsComments(*examplesAsComments))
+	compilerOptions = append(compilerOptions, compiler.WithSecurityAsComment(*securityAsComment))
+	compilerOptions = append(compilerOptions, compiler.WithSecurityAsOption(*securityAsOption))
+	compilerOptions = append(compilerOptions, compiler.WithConcreteEmptyMessages(*concreteEmptyMessages))
+	compilerOptions = append(compilerOptions, compiler.WithEmptyType(*emptyType))
+	compilerOptions = append(compilerOptions, compiler.WithSplitReadWriteOnly(*splitReadWriteOnly))
+	compilerOptions = append(compilerOptions, compiler.WithRequestSuffix(*requestSuffix))
+	compilerOptions = append(compilerOptions, compiler.WithResponseSuffix(*responseSuffix))
+	compilerOptions = append(compilerOptions, compiler.WithErrorResponses(*errorResponses))
+	compilerOptions = append(compilerOptions, compiler.WithServiceName(*serviceName))
+	compilerOptions = append(compilerOptions, compiler.WithJSONNameOption(*jsonNameOption))
+	compilerOptions = append(compilerOptions, compiler.WithFieldNumberBase(*fieldNumberBase))
+	compilerOptions = append(compilerOptions, compiler.WithDefaultIntegerType(*defaultIntegerType))
+	compilerOptions = append(compilerOptions, compiler.WithUseSchemaTitle(*useSchemaTitle))
+	compilerOptions = append(compilerOptions, compiler.WithTopLevelEnums(*topLevelEnums))
+	compilerOptions = append(compilerOptions, compiler.WithEmptyObjectAsStruct(*emptyObjectAsStruct))
+	compilerOptions = append(compilerOptions, compiler.WithWellKnownTypes(*wellKnownTypes))
+	compilerOptions = append(compilerOptions, compiler.WithPruneUnusedMessages(*pruneUnusedMessages))
+	compilerOptions = append(compilerOptions, compiler.WithPruneRoots(pruneRoots))
+	compilerOptions = append(compilerOptions, compiler.WithAdditionalPropertiesComment(*additionalPropertiesComment))
+	compilerOptions = append(compilerOptions, compiler.WithIncludeTags(includeTags))
+	compilerOptions = append(compilerOptions, compiler.WithExcludeTags(excludeTags))
+	compilerOptions = append(compilerOptions, compiler.WithExcludeDefinitions(excludeDefinitions))
+	if *singularize {
+		compilerOptions = append(compilerOptions, compiler.WithInflector(compiler.Singularize))
+	}
 
 	encoderOptions = append(encoderOptions, protobuf.WithAutogeneratedComment(*addAutogeneratedComment))
+	encoderOptions = append(encoderOptions, protobuf.WithSyntax(*syntax))
+	encoderOptions = append(encoderOptions, protobuf.WithBlankLinesBetweenMessages(*blankLines))
+	encoderOptions = append(encoderOptions, protobuf.WithServiceRPCOrder(*rpcOrder))
+	if *generatedHeader {
+		encoderOptions = append(encoderOptions, protobuf.WithGeneratedHeader(*specPath, *generatedHeaderVersion))
+	}
 
 	if *indent > 0 {
 		var indentStr bytes.Buffer
@@ -62,16 +150,79 @@ func _main() error {
 		encoderOptions = append(encoderOptions, protobuf.WithIndent(indentStr.String()))
 	}
 
-	if len(compilerOptions) > 0 {
-		options = append(options, openapi2proto.WithCompilerOptions(compilerOptions...))
-	}
+	// lossy conversions are reported, but don't fail the run
+	warnings := compiler.WithWarningHandler(func(w compiler.Warning) {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", *specPath, w)
+	})
 
-	if len(encoderOptions) > 0 {
-		options = append(options, openapi2proto.WithEncoderOptions(encoderOptions...))
+	if *validate {
+		spec, err := openapi.LoadFile(*specPath)
+		if err != nil {
+			return errors.Wrap(err, `failed to load OpenAPI spec`)
+		}
+		problems := compiler.Validate(spec, append(compilerOptions, warnings)...)
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *specPath, problem)
+		}
+		if len(problems) > 0 {
+			return errors.Errorf(`found %d problem(s) in %s`, len(problems), *specPath)
+		}
+		return nil
 	}
 
-	if err := openapi2proto.Transpile(dst, *specPath, options...); err != nil {
+	compilerOptions = append(compilerOptions, warnings)
+
+	p, err := compiler.CompileFile(*specPath, compilerOptions...)
+	if err != nil {
 		return errors.Wrap(err, `failed to transpile`)
 	}
+	defaults := compiler.PackageDefaults(p)
+
+	switch {
+	case *check:
+		// -check never writes anything
+		var buf bytes.Buffer
+		if err := protobuf.NewEncoder(&buf, encoderOptions...).Encode(p); err != nil {
+			return errors.Wrap(err, `failed to transpile`)
+		}
+		upToDate, err := checkProto(os.Stderr, *outfile, buf.Bytes(), *ignoreTrailingWhitespace)
+		if err != nil {
+			return err
+		}
+		if !upToDate {
+			return errors.Errorf(`%s is not up to date with %s`, *outfile, *specPath)
+		}
+		return nil
+	case *outdir != "":
+		if err := protobuf.EncodeToFS(*outdir, p, encoderOptions...); err != nil {
+			return errors.Wrap(err, `failed to transpile`)
+		}
+	case *outfile != "":
+		// custom regions of an existing output file are kept
+		if err := protobuf.EncodeToFile(*outfile, p, encoderOptions...); err != nil {
+			return errors.Wrap(err, `failed to transpile`)
+		}
+	default:
+		if err := protobuf.NewEncoder(os.Stdout, encoderOptions...).Encode(p); err != nil {
+			return errors.Wrap(err, `failed to transpile`)
+		}
+	}
+
+	// the defaults are only written along with the declaration
+	if *defaultsOut != "" {
+		if err := writeDefaults(*defaultsOut, defaults); err != nil {
+			return errors.Wrap(err, `failed to write defaults`)
+		}
+	}
 	return nil
 }
+
+// writeDefaults writes the default values of the fields of the messages
+// compiled from the spec to the file `fn`, as indented JSON
+func writeDefaults(fn string, defaults map[string]interface{}) error {
+	buf, err := json.MarshalIndent(defaults, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, `failed to encode defaults`)
+	}
+	return ioutil.WriteFile(fn, append(buf, '\n'), 0644)
+}
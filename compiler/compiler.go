@@ -4,8 +4,14 @@ package compiler // github.com/NYTimes/openapi2proto/compiler
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/NYTimes/openapi2proto/openapi"
 	"github.com/NYTimes/openapi2proto/protobuf"
@@ -14,6 +20,7 @@ import (
 
 var builtinTypes = map[string]protobuf.Type{
 	"bytes":               protobuf.BytesType,
+	"file":                protobuf.BytesType,
 	"string":              protobuf.StringType,
 	"integer":             protobuf.NewMessage("pseudo:integer"),
 	"float":               protobuf.NewMessage("pseudo:float"),
@@ -24,6 +31,7 @@ var builtinTypes = map[string]protobuf.Type{
 
 var knownImports = map[string]string{
 	"google.protobuf.Any":           "google/protobuf/any.proto",
+	"google.protobuf.Duration":      "google/protobuf/duration.proto",
 	"google.protobuf.Empty":         "google/protobuf/empty.proto",
 	"google.protobuf.NullValue":     "google/protobuf/struct.proto",
 	"google.protobuf.MethodOptions": "google/protobuf/descriptor.proto",
@@ -46,73 +54,263 @@ func init() {
 
 func newCompileCtx(spec *openapi.Spec, options ...Option) *compileCtx {
 	p := protobuf.NewPackage(packageName(spec.Info.Title))
-	svc := protobuf.NewService(normalizeServiceName(spec.Info.Title))
-	p.AddType(svc)
 
 	var annotate bool
 	var skipRpcs bool
+	var messagesOnly bool
 	var skipDeprecatedRpcs bool
 	var prefixEnums bool
 	var wrapPrimitives bool
+	var extraImports []string
+	var tagsAsComment bool
+	var tagsAsOption string
+	var securityAsComment bool
+	var emptyObjectAsStruct bool
+	var topLevelEnums bool
+	var useSchemaTitle bool
+	var examplesAsComments bool
+	var emptyType string
+	var securityAsOption string
+	var concreteEmptyMessages bool
+	var splitReadWriteOnly bool
+	var inflector func(string) string
+	var operationIDTransformer func(string) string
+	var warningHandler func(Warning)
+	var typeMapper TypeMapper
+	var errorResponses bool
+	var serviceName string
+	var additionalPropertiesComment bool
+	var jsonNameOption bool
+	var pruneUnusedMessages bool
+	var pruneRoots []string
+	var wellKnownTypes bool
+	var httpComment bool
+	fieldNumberBase := 1
+	defaultIntegerType := "int32"
+	requestSuffix := "Request"
+	responseSuffix := "Response"
+	includeTags := map[string]struct{}{}
+	excludeTags := map[string]struct{}{}
+	excludeDefinitions := map[string]struct{}{}
+	ignoreParamLocations := map[string]struct{}{}
 	for _, o := range options {
 		switch o.Name() {
 		case optkeyAnnotation:
 			annotate = o.Value().(bool)
 		case optkeySkipRpcs:
 			skipRpcs = o.Value().(bool)
+		case optkeyMessagesOnly:
+			messagesOnly = o.Value().(bool)
 		case optKeySkipDeprecatedRpcs:
 			skipDeprecatedRpcs = o.Value().(bool)
 		case optkeyPrefixEnums:
 			prefixEnums = o.Value().(bool)
 		case optkeyWrapPrimitives:
 			wrapPrimitives = o.Value().(bool)
+		case optkeyIgnoreParamLocations:
+			for _, l := range o.Value().([]string) {
+				ignoreParamLocations[strings.ToLower(l)] = struct{}{}
+			}
+		case optkeyExtraImports:
+			extraImports = append(extraImports, o.Value().([]string)...)
+		case optkeyTagsAsComment:
+			tagsAsComment = o.Value().(bool)
+		case optkeyTagsAsOption:
+			tagsAsOption = o.Value().(string)
+		case optkeyFieldNumberBase:
+			fieldNumberBase = o.Value().(int)
+		case optkeyEmptyType:
+			emptyType = o.Value().(string)
+		case optkeyDefaultIntegerType:
+			defaultIntegerType = o.Value().(string)
+		case optkeyWarningHandler:
+			warningHandler = o.Value().(func(Warning))
+		case optkeyExamplesAsComments:
+			examplesAsComments = o.Value().(bool)
+		case optkeyUseSchemaTitle:
+			useSchemaTitle = o.Value().(bool)
+		case optkeyTopLevelEnums:
+			topLevelEnums = o.Value().(bool)
+		case optkeyEmptyObjectAsStruct:
+			emptyObjectAsStruct = o.Value().(bool)
+		case optkeySecurityAsComment:
+			securityAsComment = o.Value().(bool)
+		case optkeySecurityAsOption:
+			securityAsOption = o.Value().(string)
+		case optkeyConcreteEmptyMessages:
+			concreteEmptyMessages = o.Value().(bool)
+		case optkeySplitReadWriteOnly:
+			splitReadWriteOnly = o.Value().(bool)
+		case optkeyInflector:
+			inflector = o.Value().(func(string) string)
+		case optkeyOperationIDTransformer:
+			operationIDTransformer = o.Value().(func(string) string)
+		case optkeyTypeMapper:
+			typeMapper = o.Value().(TypeMapper)
+		case optkeyErrorResponses:
+			errorResponses = o.Value().(bool)
+		case optkeyServiceName:
+			serviceName = o.Value().(string)
+		case optkeyJSONNameOption:
+			jsonNameOption = o.Value().(bool)
+		case optkeyPruneUnusedMessages:
+			pruneUnusedMessages = o.Value().(bool)
+		case optkeyPruneRoots:
+			pruneRoots = o.Value().([]string)
+		case optkeyWellKnownTypes:
+			wellKnownTypes = o.Value().(bool)
+		case optkeyHTTPComment:
+			httpComment = o.Value().(bool)
+		case optkeyRequestSuffix:
+			requestSuffix = o.Value().(string)
+		case optkeyResponseSuffix:
+			responseSuffix = o.Value().(string)
+		case optkeyAdditionalPropertiesComment:
+			additionalPropertiesComment = o.Value().(bool)
+		case optkeyIncludeTags:
+			for _, tag := range o.Value().([]string) {
+				includeTags[tag] = struct{}{}
+			}
+		case optkeyExcludeTags:
+			for _, tag := range o.Value().([]string) {
+				excludeTags[tag] = struct{}{}
+			}
+		case optkeyExcludeDefinitions:
+			for _, name := range o.Value().([]string) {
+				excludeDefinitions[name] = struct{}{}
+			}
 		}
 	}
 
+	if serviceName == "" {
+		serviceName = normalizeServiceName(spec.Info.Title)
+	}
+	var svc *protobuf.Service
+	if !messagesOnly {
+		svc = protobuf.NewService(serviceName)
+		p.AddType(svc)
+	}
+
 	c := &compileCtx{
-		annotate:            annotate,
-		skipRpcs:            skipRpcs,
-		skipDeprecatedRpcs:  skipDeprecatedRpcs,
-		prefixEnums:         prefixEnums,
-		wrapPrimitives:      wrapPrimitives,
-		definitions:         map[string]protobuf.Type{},
-		externalDefinitions: map[string]map[string]protobuf.Type{},
-		imports:             map[string]struct{}{},
-		pkg:                 p,
-		phase:               phaseInvalid,
-		rpcs:                map[string]*protobuf.RPC{},
-		spec:                spec,
-		service:             svc,
-		types:               map[protobuf.Container]map[protobuf.Type]struct{}{},
-		unfulfilledRefs:     map[string]struct{}{},
-		messageNames:        map[string]bool{},
-		wrapperMessages:     map[string]bool{},
+		annotate:                    annotate,
+		skipRpcs:                    skipRpcs,
+		messagesOnly:                messagesOnly,
+		skipDeprecatedRpcs:          skipDeprecatedRpcs,
+		prefixEnums:                 prefixEnums,
+		wrapPrimitives:              wrapPrimitives,
+		ignoreParamLocations:        ignoreParamLocations,
+		extraImports:                extraImports,
+		tagsAsComment:               tagsAsComment,
+		tagsAsOption:                tagsAsOption,
+		securityAsComment:           securityAsComment,
+		emptyObjectAsStruct:         emptyObjectAsStruct,
+		topLevelEnums:               topLevelEnums,
+		useSchemaTitle:              useSchemaTitle,
+		examplesAsComments:          examplesAsComments,
+		emptyType:                   emptyType,
+		fieldNumberBase:             fieldNumberBase,
+		defaultIntegerType:          defaultIntegerType,
+		securityAsOption:            securityAsOption,
+		concreteEmptyMessages:       concreteEmptyMessages,
+		splitReadWriteOnly:          splitReadWriteOnly,
+		splitDefinitions:            map[string]struct{}{},
+		inflector:                   inflector,
+		operationIDTransformer:      operationIDTransformer,
+		warningHandler:              warningHandler,
+		warned:                      map[Warning]struct{}{},
+		typeMapper:                  typeMapper,
+		errorResponses:              errorResponses,
+		includeTags:                 includeTags,
+		additionalPropertiesComment: additionalPropertiesComment,
+		jsonNameOption:              jsonNameOption,
+		pruneUnusedMessages:         pruneUnusedMessages,
+		pruneRoots:                  pruneRoots,
+		wellKnownTypes:              wellKnownTypes,
+		httpComment:                 httpComment,
+		requestSuffix:               requestSuffix,
+		responseSuffix:              responseSuffix,
+		excludeTags:                 excludeTags,
+		excludeDefinitions:          excludeDefinitions,
+		definitions:                 map[string]protobuf.Type{},
+		externalDefinitions:         map[string]map[string]protobuf.Type{},
+		imports:                     map[string]struct{}{},
+		typeImports:                 map[string]struct{}{},
+		enumNames:                   newNameCache(normalizeEnumName),
+		fieldNames:                  newNameCache(normalizeFieldName),
+		snakeCaseNames:              newNameCache(snakeCase),
+		pkg:                         p,
+		phase:                       phaseInvalid,
+		rpcs:                        map[string]*protobuf.RPC{},
+		spec:                        spec,
+		service:                     svc,
+		types:                       map[protobuf.Container]map[protobuf.Type]struct{}{},
+		unfulfilledRefs:             map[string]struct{}{},
+		messageNames:                map[string]bool{},
+		wrapperMessages:             map[string]bool{},
+		mapWrappers:                 map[string]*protobuf.Message{},
+		hoistedEnums:                map[string]hoistedEnum{},
 	}
 	return c
 }
 
 // Compile takes an OpenAPI spec and compiles it into a protobuf.Package.
 func Compile(spec *openapi.Spec, options ...Option) (*protobuf.Package, error) {
-	c := newCompileCtx(spec, options...)
+	c := newCompileCtx(mergeComponents(spec), options...)
 	c.pushParent(c.pkg)
 
-	if c.annotate {
+	if c.emptyType != "" && !isQualifiedName(c.emptyType) {
+		return nil, errors.Errorf(`invalid empty type %q: must be a message name, optionally qualified with its package`, c.emptyType)
+	}
+	if c.fieldNumberBase < 1 || c.fieldNumberBase > maxFieldNumber {
+		return nil, errors.Errorf(`invalid field number base %d: must be between 1 and %d`, c.fieldNumberBase, maxFieldNumber)
+	}
+	// the messages of an rpc would replace each other, or collide with
+	// the messages of the definitions
+	if c.requestSuffix == "" || c.responseSuffix == "" {
+		return nil, errors.Errorf(`invalid request suffix %q and response suffix %q: must not be empty`, c.requestSuffix, c.responseSuffix)
+	}
+	if c.requestSuffix == c.responseSuffix {
+		return nil, errors.Errorf(`invalid request and response suffixes: both are %q`, c.requestSuffix)
+	}
+	switch c.defaultIntegerType {
+	case "int32", "int64":
+	default:
+		return nil, errors.Errorf(`invalid default integer type %q: must be int32 or int64`, c.defaultIntegerType)
+	}
+	// without rpcs, nothing would be left but the prune roots
+	if (c.messagesOnly || c.skipRpcs) && c.pruneUnusedMessages && len(c.pruneRoots) == 0 {
+		return nil, errors.New(`pruning unused messages without compiling rpcs requires prune roots, as there are no rpcs to keep messages for`)
+	}
+
+	if c.annotate && !c.messagesOnly {
 		c.addImport("google/api/annotations.proto")
 	}
 
+	for _, lib := range c.extraImports {
+		c.addImport(lib)
+	}
+	for _, lib := range spec.ProtoImports {
+		c.addImport(lib)
+	}
+
+	// the description of the API is kept as the comment of the file
+	if len(strings.TrimSpace(spec.Info.Description)) > 0 {
+		c.pkg.SetComment(makeComment(spec.Info.Title, spec.Info.Description))
+	}
+
 	if err := c.compileGlobalOptions(spec.GlobalOptions); err != nil {
 		return nil, errors.Wrap(err, `failed to compile global options`)
 	}
 
 	// compile all definitions
-	if err := c.compileDefinitions(spec.Definitions); err != nil {
-		return nil, errors.Wrap(err, `failed to compile definitions`)
+	if err := c.compileDefinitions(c.spec.Definitions); err != nil {
+		return nil, errors.Wrap(c.locateError(err), `failed to compile definitions`)
 	}
-	if err := c.compileParameters(spec.Parameters); err != nil {
-		return nil, errors.Wrap(err, `failed to compile parameters`)
+	if err := c.compileParameters(c.spec.Parameters); err != nil {
+		return nil, errors.Wrap(c.locateError(err), `failed to compile parameters`)
 	}
-	if err := c.compileResponses(spec.Responses); err != nil {
-		return nil, errors.Wrap(err, `failed to compile global responses`)
+	if err := c.compileResponses(c.spec.Responses); err != nil {
+		return nil, errors.Wrap(c.locateError(err), `failed to compile global responses`)
 	}
 
 	p2, err := protobuf.Resolve(c.pkg, c.getTypeFromReference)
@@ -132,19 +330,97 @@ func Compile(spec *openapi.Spec, options ...Option) (*protobuf.Package, error) {
 	}
 
 	// compile the paths
-	if !c.skipRpcs {
+	if !c.skipRpcs && !c.messagesOnly {
 		c.phase = phaseCompilePaths
 		if err := c.compilePaths(spec.Paths); err != nil {
-			return nil, errors.Wrap(err, `failed to compile paths`)
+			return nil, errors.Wrap(c.locateError(err), `failed to compile paths`)
+		}
+	}
+
+	if c.pruneUnusedMessages {
+		protobuf.Prune(c.pkg, c.pruneRoots...)
+		if err := c.pruneImports(); err != nil {
+			return nil, errors.Wrap(err, `failed to prune imports`)
 		}
 	}
 
 	return c.pkg, nil
 }
 
+// mergeComponents returns a copy of the spec, where the schemas, parameters
+// and responses declared in the components of an OpenAPI 3 spec are
+// merged into the definitions, parameters and responses of Swagger 2.0.
+// declarations at the top level take precedence
+func mergeComponents(spec *openapi.Spec) *openapi.Spec {
+	components := spec.Components
+	if len(components.Schemas) == 0 && len(components.Parameters) == 0 && len(components.Responses) == 0 {
+		return spec
+	}
+
+	merged := *spec
+	merged.Definitions = map[string]*openapi.Schema{}
+	for _, m := range []map[string]*openapi.Schema{components.Schemas, spec.Definitions} {
+		for name, s := range m {
+			merged.Definitions[name] = s
+		}
+	}
+	merged.Parameters = map[string]*openapi.Parameter{}
+	for _, m := range []map[string]*openapi.Parameter{components.Parameters, spec.Parameters} {
+		for name, p := range m {
+			merged.Parameters[name] = p
+		}
+	}
+	merged.Responses = map[string]*openapi.Response{}
+	for _, m := range []map[string]*openapi.Response{components.Responses, spec.Responses} {
+		for name, r := range m {
+			merged.Responses[name] = r
+		}
+	}
+	return &merged
+}
+
+// CompileFile is a convenience function that loads an OpenAPI spec
+// from a file (or a remote HTTP(s) location), and compiles it into a
+// protobuf.Package.
+func CompileFile(path string, options ...Option) (*protobuf.Package, error) {
+	s, err := openapi.LoadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to load OpenAPI spec`)
+	}
+
+	p, err := Compile(s, options...)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to compile OpenAPI spec to Protocol buffers`)
+	}
+	return p, nil
+}
+
+// Validate compiles the OpenAPI spec, and checks the result for
+// problems that would make the generated declaration invalid, without
+// encoding it. If the spec can't be compiled, the error from the
+// compilation is the only problem reported.
+func Validate(spec *openapi.Spec, options ...Option) []error {
+	p, err := Compile(spec, options...)
+	if err != nil {
+		return []error{err}
+	}
+	return protobuf.Validate(p)
+}
+
 func (c *compileCtx) compileGlobalOptions(options openapi.GlobalOptions) error {
 	for k, v := range options {
-		c.pkg.AddOption(protobuf.NewGlobalOption(k, v))
+		switch v := v.(type) {
+		case string:
+			c.pkg.AddOption(protobuf.NewGlobalOption(k, v))
+		case bool:
+			c.pkg.AddOption(protobuf.NewGlobalOption(k, strconv.FormatBool(v)))
+		case float64:
+			c.pkg.AddOption(protobuf.NewLiteralGlobalOption(k, strconv.FormatFloat(v, 'f', -1, 64)))
+		case map[string]interface{}:
+			c.pkg.AddOption(protobuf.NewAggregateGlobalOption(k, v))
+		default:
+			return errors.Errorf(`global option %s must be a string, a number, a boolean or a map, got %T`, k, v)
+		}
 	}
 	return nil
 }
@@ -166,6 +442,84 @@ func makeComment(summary, description string) string {
 	return buf.String()
 }
 
+// schemaComment returns the comment for the field, message or enum
+// generated for the schema: x-proto-comment if given, as descriptions
+// are often written for API consumers, or the description otherwise
+func schemaComment(s *openapi.Schema) string {
+	if v := s.ProtoComment; v != "" {
+		return v
+	}
+	return s.Description
+}
+
+// validationKeywords returns the validation keywords used by the
+// schema, which protobuf has no way to enforce
+func validationKeywords(s *openapi.Schema) []string {
+	var keywords []string
+	if s.Pattern != "" {
+		keywords = append(keywords, "pattern")
+	}
+	if s.MinLength != 0 {
+		keywords = append(keywords, "minLength")
+	}
+	if s.MaxLength != 0 {
+		keywords = append(keywords, "maxLength")
+	}
+	if s.Minimum != 0 || s.ExclusiveMinimum {
+		keywords = append(keywords, "minimum")
+	}
+	if s.Maximum != 0 || s.ExclusiveMaximum {
+		keywords = append(keywords, "maximum")
+	}
+	if s.MultipleOf != 0 {
+		keywords = append(keywords, "multipleOf")
+	}
+	return keywords
+}
+
+// schemas with `not` are compiled to google.protobuf.Any, so the
+// constraint is at least documented in the comment of the field
+func notComment(s *openapi.Schema) string {
+	not := s.Not
+	if not == nil {
+		return ""
+	}
+
+	var constraint string
+	switch {
+	case not.Ref != "":
+		constraint = "must not match " + not.Ref
+	case len(not.Enum) > 0:
+		constraint = "must not be one of " + strings.Join(not.Enum, ", ")
+	case not.Type.Len() > 0:
+		constraint = "must not be of type " + strings.Join(not.Type, " or ")
+	default:
+		constraint = "must not match the schema given by `not`"
+	}
+	return "Not enforced: the value " + constraint
+}
+
+// proto3 has no way to express example and default values,
+// so we leave them in the comments instead
+func exampleComment(s *openapi.Schema) string {
+	var lines []string
+	if s.Example != nil {
+		lines = append(lines, "Example: "+formatValue(s.Example))
+	}
+	if s.Default != nil {
+		lines = append(lines, "Default: "+formatValue(s.Default))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
 func extractComment(v interface{}) string {
 	switch v := v.(type) {
 	case *openapi.Schema:
@@ -178,28 +532,155 @@ func extractComment(v interface{}) string {
 
 func (c *compileCtx) compileDefinitions(definitions map[string]*openapi.Schema) error {
 	c.phase = phaseCompileDefinitions
-	for ref, schema := range definitions {
-		m, err := c.compileSchema(camelCase(ref), schema)
+
+	// definitions with read-only or write-only properties are compiled
+	// twice: once for responses, and once for requests. we need to know
+	// about all of them beforehand, as they may refer to each other
+	if c.splitReadWriteOnly {
+		for ref, schema := range definitions {
+			if hasReadWriteOnlyProperties(schema) {
+				c.splitDefinitions["#/definitions/"+ref] = struct{}{}
+			}
+		}
+	}
+
+	// definitions are compiled in a fixed order, as names may collide
+	// (e.g. titles), in which case the first definition wins
+	var refs []string
+	for ref := range definitions {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	for _, ref := range refs {
+		schema := definitions[ref]
+		if _, ok := c.excludeDefinitions[ref]; ok {
+			continue
+		}
+
+		c.pushBreadcrumb("#/definitions/" + ref)
+		c.refPaths = []string{"#/definitions/" + ref}
+		var m protobuf.Type
+		var err error
+		if isArrayDefinition(schema) {
+			m, err = c.compileArrayWrapper(camelCase(ref), schema)
+		} else if isMapSchema(schema) {
+			// compiled to the map directly, as the wrapper declared by
+			// compileNestedReference may already have taken the name
+			m, err = c.compileMap(camelCase(ref), camelCase(ref), schema.AdditionalProperties)
+		} else {
+			m, err = c.compileSchema(camelCase(ref), schema)
+		}
+		c.refPaths = nil
 		if err != nil {
 			return errors.Wrapf(err, `failed to compile #/definition/%s`, ref)
 		}
 		c.addDefinition("#/definitions/"+ref, m)
+
+		for _, lib := range schema.ProtoImports {
+			c.addImport(lib)
+		}
+
+		// make sure that references using the custom name also resolve
+		if v := schema.ProtoMessageName; v != "" {
+			c.addDefinition("#/definitions/"+v, m)
+		}
+		if v := schema.ProtoEnumName; v != "" {
+			c.addDefinition("#/definitions/"+v, m)
+		}
+
+		if _, ok := c.splitDefinitions["#/definitions/"+ref]; ok {
+			if err := c.compileRequestDefinition(ref, schema); err != nil {
+				return errors.Wrapf(err, `failed to compile request variant of #/definition/%s`, ref)
+			}
+		}
+		c.popBreadcrumb()
 	}
 	return nil
 }
 
+// compileRequestDefinition compiles the variant of a definition that is
+// used in requests, which does not contain read-only properties. It is
+// named FooRequest, and can be looked up using requestRef
+func (c *compileCtx) compileRequestDefinition(ref string, schema *openapi.Schema) error {
+	copy := *schema
+	name := camelCase(ref) + "Request"
+	if v := copy.ProtoMessageName; v != "" {
+		copy.ProtoMessageName = v + "Request"
+	}
+	if v := copy.Title; v != "" {
+		copy.Title = v + " Request"
+	}
+
+	c.inRequest = true
+	c.refPaths = []string{requestRefPrefix + "#/definitions/" + ref}
+	m, err := c.compileSchema(name, &copy)
+	c.refPaths = nil
+	c.inRequest = false
+	if err != nil {
+		return err
+	}
+
+	c.addDefinition(requestRefPrefix+"#/definitions/"+ref, m)
+	if v := schema.ProtoMessageName; v != "" {
+		c.addDefinition(requestRefPrefix+"#/definitions/"+v, m)
+	}
+	return nil
+}
+
+// requestRef returns the key under which the request variant of the
+// referenced definition is registered, when compiling a request and
+// the definition has one. Otherwise, the reference is returned as is
+func (c *compileCtx) requestRef(ref string) string {
+	if !c.inRequest {
+		return ref
+	}
+	if _, ok := c.splitDefinitions[ref]; !ok {
+		return ref
+	}
+	return requestRefPrefix + ref
+}
+
+// hasReadWriteOnlyProperties returns true if any of the properties of
+// the schema, including those of inline objects, is marked as readOnly
+// or writeOnly
+func hasReadWriteOnlyProperties(s *openapi.Schema) bool {
+	if s == nil || s.Ref != "" {
+		return false
+	}
+	for _, prop := range s.Properties {
+		if prop.ReadOnly || prop.WriteOnly || hasReadWriteOnlyProperties(prop) {
+			return true
+		}
+	}
+	return hasReadWriteOnlyProperties(s.Items)
+}
+
 // Note: compiles GLOBAL parameters. not to be used for compiling
 // actual parameters
 func (c *compileCtx) compileParameters(parameters map[string]*openapi.Parameter) error {
 	c.phase = phaseCompileDefinitions
-	for ref, param := range parameters {
+
+	var refs []string
+	for ref := range parameters {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+
+	for _, ref := range refs {
+		param := parameters[ref]
+		c.pushBreadcrumb("#/parameters/" + ref)
 		_, s, err := c.compileParameterToSchema(param)
+		c.inRequest = true
 		m, err := c.compileSchema(camelCase(ref), s)
+		c.inRequest = false
 		if err != nil {
 			return errors.Wrapf(err, `failed to compile #/parameters/%s`, ref)
 		}
 
-		pname := m.Name()
+		// the name of the type is not a good name for the field when
+		// the parameter is a scalar (e.g. int32), so use the key instead
+		pname := ref
 		repeated := false
 
 		// Now this is really really annoying, but sometimes the values in
@@ -211,16 +692,18 @@ func (c *compileCtx) compileParameters(parameters map[string]*openapi.Parameter)
 
 		// Now this REALLY REALLY sucks, but we need to detect if the parameter
 		// should be "repeated" by detecting if the enclosing type is an array.
-		if param.Items != nil {
+		if param.Items != nil || (param.Schema != nil && param.Schema.Items != nil) {
 			repeated = true
 		}
 
 		m = &Parameter{
-			Type:          m,
-			parameterName: pname,
-			repeated:      repeated,
+			Type:            m,
+			parameterName:   c.snakeCaseNames.convert(pname),
+			parameterNumber: int(param.ProtoTag),
+			repeated:        repeated,
 		}
 		c.addDefinition("#/parameters/"+ref, m)
+		c.popBreadcrumb()
 	}
 	return nil
 }
@@ -229,20 +712,97 @@ func (c *compileCtx) compileParameters(parameters map[string]*openapi.Parameter)
 // actual endpoint responses
 func (c *compileCtx) compileResponses(responses map[string]*openapi.Response) error {
 	c.phase = phaseCompileDefinitions
-	for name, response := range responses {
+
+	var names []string
+	for name := range responses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		response := responses[name]
+		c.pushBreadcrumb("#/responses/" + name)
 		if response.Schema == nil {
 			c.addDefinition("#/responses/"+name, protobuf.NewMessage(name))
+			c.popBreadcrumb()
 			continue
 		}
-		m, err := c.compileSchema(camelCase(name), response.Schema)
+		var m protobuf.Type
+		var err error
+		if isScalarSchema(response.Schema) {
+			m, err = c.compileValueWrapper(camelCase(name), response.Schema)
+			if err == nil {
+				c.addType(m)
+			}
+		} else {
+			m, err = c.compileSchema(camelCase(name), response.Schema)
+		}
 		if err != nil {
-			return errors.Wrapf(err, `failed to compile #/parameters/%s`, name)
+			return errors.Wrapf(err, `failed to compile #/responses/%s`, name)
 		}
 		c.addDefinition("#/responses/"+name, m)
+		c.popBreadcrumb()
 	}
 	return nil
 }
 
+// isScalarSchema returns true if the schema describes an inline value
+// that will not be compiled into a message, such as a string or an enum
+func isScalarSchema(s *openapi.Schema) bool {
+	if s.Ref != "" || len(s.AllOf) > 0 || s.Type.Empty() {
+		return false
+	}
+	return !s.Type.Contains("object") && !s.Type.Contains("array")
+}
+
+// compileValueWrapper creates a message that holds the value described
+// by the schema in a single "value" field, for places where protobuf
+// requires a message. Enums are declared within the message
+func (c *compileCtx) compileValueWrapper(name string, s *openapi.Schema) (*protobuf.Message, error) {
+	m := protobuf.NewMessage(name)
+	c.pushParent(m)
+	typ, err := c.compileSchema("value", s)
+	c.popParent()
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to compile value of %s`, name)
+	}
+	c.addImportForType(typ.Name())
+	m.AddField(protobuf.NewField(typ, "value", 1))
+	return m, nil
+}
+
+// arrays are not messages, so a definition of an array is compiled
+// to a Foo { repeated Bar items } message, like array responses
+func isArrayDefinition(s *openapi.Schema) bool {
+	return s.Ref == "" && s.Type.Len() == 1 && s.Type.Contains("array") && s.Items != nil
+}
+
+func (c *compileCtx) compileArrayWrapper(name string, s *openapi.Schema) (*protobuf.Message, error) {
+	if v := c.schemaTitle(s); v != "" {
+		name = v
+	}
+	if v := s.ProtoMessageName; v != "" {
+		name = v
+	}
+
+	m := protobuf.NewMessage(name)
+	if v := schemaComment(s); len(v) > 0 {
+		m.SetComment(v)
+	}
+	c.pushParent(m)
+	typ, err := c.compileItems("items", s.Items)
+	c.popParent()
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to compile items of %s`, name)
+	}
+	c.addImportForType(typ.Name())
+	f := protobuf.NewField(typ, "items", 1)
+	f.SetRepeated(true)
+	m.AddField(f)
+	c.addType(m)
+	return m, nil
+}
+
 func (c *compileCtx) compileExtension(ext *openapi.Extension) (*protobuf.Extension, error) {
 	e := protobuf.NewExtension(ext.Base)
 	for _, f := range ext.Fields {
@@ -264,47 +824,302 @@ func (c *compileCtx) compileParameterToSchema(param *openapi.Parameter) (string,
 		if err != nil {
 			return "", nil, errors.Wrapf(err, `failed to get type for reference %s`, param.Ref)
 		}
+		// the field is named after the referenced parameter, as the
+		// key it is declared under is only meant to identify it
 		var name = param.Name
+		global := c.globalParameter(param.Ref)
+		if name == "" && global != nil {
+			name = global.Name
+		}
 		if name == "" {
 			if i := strings.LastIndexByte(param.Ref, '/'); i > -1 {
 				name = param.Ref[i+1:]
 			}
 		}
-		return snakeCase(name), &openapi.Schema{
+		s := &openapi.Schema{
 			ProtoName: name,
 			Ref:       param.Ref,
 			ProtoTag:  param.ProtoTag,
-		}, nil
+		}
+		// the type is taken from the compiled parameter, but the
+		// documentation and the default value are only in the spec
+		if global != nil {
+			s.Description = parameterComment(global)
+			s.Default = global.Default
+		}
+		return c.snakeCaseNames.convert(name), s, nil
 	case param.Schema != nil:
 		s2 := *param.Schema
 		s2.ProtoName = param.Name
-		s2.Description = param.Description
+		s2.Description = parameterComment(param)
 		s2.ProtoTag = param.ProtoTag
-		return snakeCase(param.Name), &s2, nil
+		return c.snakeCaseNames.convert(param.Name), &s2, nil
 	default:
-		return snakeCase(param.Name), &openapi.Schema{
-			Type:        param.Type,
-			Enum:        param.Enum,
-			Format:      param.Format,
-			Items:       param.Items,
-			ProtoName:   param.Name,
-			ProtoTag:    param.ProtoTag,
-			Description: param.Description,
+		return c.snakeCaseNames.convert(param.Name), &openapi.Schema{
+			Type:             param.Type,
+			Default:          param.Default,
+			Enum:             param.Enum,
+			EnumDescriptions: param.EnumDescriptions,
+			EnumVarNames:     param.EnumVarNames,
+			Format:           param.Format,
+			Items:            param.Items,
+			ProtoName:        param.Name,
+			ProtoTag:         param.ProtoTag,
+			Description:      parameterComment(param),
 		}, nil
 	}
 }
 
+// requestBodyParameters converts the request body of an OpenAPI 3
+// operation to the parameters that Swagger 2.0 would declare for it.
+// JSON content becomes a body parameter. Without it, the properties of
+// form or multipart content become form fields, with files compiled to
+// bytes. Other content types are ignored
+func (c *compileCtx) requestBodyParameters(b *openapi.RequestBody) (openapi.Parameters, error) {
+	contentType, mt := selectRequestContent(b.Content)
+	if mt == nil || mt.Schema == nil {
+		return nil, nil
+	}
+
+	if isJSONContentType(contentType) {
+		return openapi.Parameters{{
+			Name:        "body",
+			Description: b.Description,
+			In:          "body",
+			Required:    b.Required,
+			Schema:      mt.Schema,
+		}}, nil
+	}
+
+	s := mt.Schema
+	if s.Ref != "" {
+		ref := normalizeRef(s.Ref)
+		def, ok := c.spec.Definitions[strings.TrimPrefix(ref, "#/definitions/")]
+		if !ok || !strings.HasPrefix(ref, "#/definitions/") {
+			return nil, errors.Errorf(`%s content must refer to a definition, got %s`, contentType, s.Ref)
+		}
+		s = def
+	}
+
+	required := map[string]bool{}
+	for _, name := range s.Required {
+		required[name] = true
+	}
+
+	var names []string
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params openapi.Parameters
+	for _, name := range names {
+		prop := s.Properties[name]
+		params = append(params, &openapi.Parameter{
+			Name:        name,
+			Description: prop.Description,
+			In:          "formData",
+			Required:    required[name],
+			Schema:      prop,
+		})
+	}
+	return params, nil
+}
+
+// the content types of request bodies that can be compiled, other than
+// JSON, in order of preference
+var formContentTypes = []string{
+	"application/x-www-form-urlencoded",
+	"multipart/form-data",
+}
+
+// selectRequestContent picks the content of a request body that the
+// request message is compiled from: JSON if present, then form fields
+func selectRequestContent(content map[string]*openapi.MediaType) (string, *openapi.MediaType) {
+	if mt, ok := content["application/json"]; ok {
+		return "application/json", mt
+	}
+
+	var contentTypes []string
+	for contentType := range content {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	for _, contentType := range contentTypes {
+		if isJSONContentType(contentType) {
+			return contentType, content[contentType]
+		}
+	}
+
+	for _, contentType := range formContentTypes {
+		if mt, ok := content[contentType]; ok {
+			return contentType, mt
+		}
+	}
+	return "", nil
+}
+
+// isJSONContentType returns true for application/json and its
+// variants, e.g. application/merge-patch+json
+func isJSONContentType(s string) bool {
+	return s == "application/json" || (strings.HasPrefix(s, "application/") && strings.HasSuffix(s, "+json"))
+}
+
+// globalParameter returns the parameter in #/parameters that the
+// reference points to, or nil if it is not such a reference
+func (c *compileCtx) globalParameter(ref string) *openapi.Parameter {
+	ref = normalizeRef(ref)
+	if !strings.HasPrefix(ref, "#/parameters/") {
+		return nil
+	}
+	return c.spec.Parameters[strings.TrimPrefix(ref, "#/parameters/")]
+}
+
+// parameterFieldName returns the name of the field of the request
+// message that the parameter is compiled to. Parameters declared in
+// #/parameters are snake cased, while the others keep their name
+func (c *compileCtx) parameterFieldName(param *openapi.Parameter) string {
+	if param.Ref != "" {
+		if t, err := c.getTypeFromReference(param.Ref); err == nil {
+			if p, ok := t.(*Parameter); ok {
+				return c.fieldNames.convert(p.ParameterName())
+			}
+		}
+	}
+	return c.fieldNames.convert(param.Name)
+}
+
+// annotationPath returns the path of the http annotation of an
+// endpoint. The variables of the path template must name fields of the
+// request message, so those that name parameters whose fields are named
+// differently, e.g. {widgetId} for a widget_id field, are renamed
+func (c *compileCtx) annotationPath(path string, params openapi.Parameters) string {
+	path = c.fullPath(path)
+	for _, param := range params {
+		if c.parameterLocation(param) != "path" {
+			continue
+		}
+
+		name := param.Name
+		if global := c.globalParameter(param.Ref); global != nil {
+			name = global.Name
+		}
+		if field := c.parameterFieldName(param); name != "" && field != name {
+			path = strings.Replace(path, "{"+name+"}", "{"+field+"}", -1)
+		}
+	}
+	return path
+}
+
+// parameterLocation returns where the parameter is sent, looking up
+// the referenced parameter if needed
+func (c *compileCtx) parameterLocation(param *openapi.Parameter) string {
+	if global := c.globalParameter(param.Ref); global != nil {
+		return global.In
+	}
+	return param.In
+}
+
+// parameterComment returns the comment of the field compiled from the
+// parameter, which adds what protobuf loses about it to its description
+func parameterComment(param *openapi.Parameter) string {
+	notes := makeComment(parameterLocationComment(param.In), collectionFormatComment(param))
+	return makeComment(param.Description, notes)
+}
+
+// formats of example query strings of array parameters, which are
+// given the name of the parameter, by Swagger 2.0 collectionFormat
+var collectionFormatExamples = map[string]string{
+	"csv":   "%[1]s=a,b",
+	"ssv":   "%[1]s=a%%20b",
+	"tsv":   "%[1]s=a%%09b",
+	"pipes": "%[1]s=a|b",
+	"multi": "%[1]s=a&%[1]s=b",
+}
+
+// repeated fields don't say how the values were serialized, so the
+// collectionFormat (Swagger 2.0) or style and explode (OpenAPI 3) of
+// array parameters is noted for consumers, when the spec gives it
+func collectionFormatComment(param *openapi.Parameter) string {
+	typ := param.Type
+	if param.Schema != nil {
+		typ = param.Schema.Type
+	}
+	if !typ.Contains("array") {
+		return ""
+	}
+
+	var format, example string
+	switch {
+	case param.CollectionFormat != "":
+		format = "Collection format: " + param.CollectionFormat
+		example = collectionFormatExamples[param.CollectionFormat]
+	case param.Style != "" || param.Explode != nil:
+		// the defaults depend on the location of the parameter
+		style := param.Style
+		if style == "" {
+			style = "simple"
+			if in := param.In; in == "query" || in == "cookie" {
+				style = "form"
+			}
+		}
+		explode := style == "form"
+		if param.Explode != nil {
+			explode = *param.Explode
+		}
+		format = fmt.Sprintf("Style: %s, explode: %t", style, explode)
+
+		switch {
+		case style == "form" && explode:
+			example = collectionFormatExamples["multi"]
+		case style == "form":
+			example = collectionFormatExamples["csv"]
+		case style == "spaceDelimited":
+			example = collectionFormatExamples["ssv"]
+		case style == "pipeDelimited":
+			example = collectionFormatExamples["pipes"]
+		}
+	default:
+		return ""
+	}
+
+	// the examples are query strings
+	if example == "" || param.In != "query" {
+		return format
+	}
+	return format + ", e.g. " + fmt.Sprintf(example, param.Name)
+}
+
+// parameters that are not part of the URL or the body are usually
+// mapped to transport metadata, so we leave a note for consumers
+func parameterLocationComment(in string) string {
+	switch in := strings.ToLower(in); in {
+	case "header", "cookie":
+		return "Sent as a " + in + " parameter"
+	}
+	return ""
+}
+
 // convert endpoint parameter list to a schema object so we can use compileSchema
 // to conver it to a message object.
 func (c *compileCtx) compileParametersToSchema(params openapi.Parameters) (*openapi.Schema, error) {
 	var s openapi.Schema
 	s.Properties = make(map[string]*openapi.Schema)
 	for _, param := range params {
+		if _, ok := c.ignoreParamLocations[strings.ToLower(c.parameterLocation(param))]; ok {
+			continue
+		}
+
 		name, schema, err := c.compileParameterToSchema(param)
 		if err != nil {
 			return nil, errors.Wrap(err, `failed to compile parameter to schema`)
 		}
 		s.Properties[name] = schema
+		if global := c.globalParameter(param.Ref); global != nil && global.Required {
+			s.Required = append(s.Required, name)
+		} else if param.Required {
+			s.Required = append(s.Required, name)
+		}
 	}
 	return &s, nil
 }
@@ -317,10 +1132,37 @@ func (c *compileCtx) compilePath(path string, p *openapi.Path) error {
 		if c.skipDeprecatedRpcs && e.Deprecated {
 			continue
 		}
+		if !c.isTagIncluded(e.Tags) {
+			continue
+		}
 
-		endpointName := normalizeEndpointName(e)
+		c.pushBreadcrumb(strings.ToUpper(e.Verb) + " " + path)
+		name, err := c.endpointName(e)
+		if err != nil {
+			return err
+		}
+		endpointName := c.uniqueEndpointName(name)
 		rpc := protobuf.NewRPC(endpointName)
-		if comment := extractComment(e); len(comment) > 0 {
+		if c.emptyType != "" {
+			empty := protobuf.NewMessage(c.emptyType)
+			rpc.SetParameter(empty)
+			rpc.SetResponse(empty)
+		}
+		comment := extractComment(e)
+		if c.httpComment {
+			comment = makeComment(strings.ToUpper(e.Verb)+" "+c.fullPath(path), comment)
+		}
+		if c.tagsAsComment && len(e.Tags) > 0 {
+			comment = makeComment(comment, "tags: "+strings.Join(e.Tags, ", "))
+		}
+		security := c.securityRequirements(e)
+		if c.securityAsComment && security != "" {
+			comment = makeComment(comment, "security: "+security)
+		}
+		if c.examplesAsComments {
+			comment = makeComment(comment, c.responseExampleComment(e))
+		}
+		if len(comment) > 0 {
 			rpc.SetComment(comment)
 		}
 
@@ -329,13 +1171,22 @@ func (c *compileCtx) compilePath(path string, p *openapi.Path) error {
 		// only accepts one request per rpc call, we need to combine the
 		// parameters and treat them as a single schema
 		params := mergeParameters(p.Parameters, e.Parameters)
+		if e.RequestBody != nil {
+			bodyParams, err := c.requestBodyParameters(e.RequestBody)
+			if err != nil {
+				return errors.Wrapf(err, `failed to compile request body for %s`, endpointName)
+			}
+			params = append(params, bodyParams...)
+		}
 		if len(params) > 0 {
 			reqSchema, err := c.compileParametersToSchema(params)
 			if err != nil {
 				return errors.Wrap(err, `failed to compile parameters to schema`)
 			}
-			reqName := endpointName + "Request"
+			reqName := endpointName + c.requestSuffix
+			c.inRequest = true
 			reqType, err := c.compileSchema(reqName, reqSchema)
+			c.inRequest = false
 			if err != nil {
 				return errors.Wrapf(err, `failed to compile parameters for %s`, endpointName)
 			}
@@ -345,6 +1196,10 @@ func (c *compileCtx) compilePath(path string, p *openapi.Path) error {
 			}
 			c.addType(reqType)
 			rpc.SetParameter(m)
+		} else if c.concreteEmptyMessages {
+			m := protobuf.NewMessage(endpointName + c.requestSuffix)
+			c.addType(m)
+			rpc.SetParameter(m)
 		}
 
 		// we can only take one response type, first one from 200/201 wins
@@ -355,31 +1210,19 @@ func (c *compileCtx) compilePath(path string, p *openapi.Path) error {
 				continue
 			}
 
-			resName := endpointName + "Response"
+			resName := endpointName + c.responseSuffix
 			if resp.Schema != nil {
-				// Wow, this *sucks*! We need to special-case when resp.Schema
-				// is an array definition, because then we need to create
-				// a FooResponse { repeated Bar field } instead of what we
-				// do in the property definition, which is to compile the
-				// Items schema and slap a repeated on it
-				if resp.Schema.Items != nil {
-					typ, err := c.compileSchema(resName, resp.Schema.Items)
-					if err != nil {
-						return errors.Wrapf(err, `failed to compile array response for %s`, endpointName)
-					}
-					m := protobuf.NewMessage(resName)
-					f := protobuf.NewField(typ, "items", 1)
-					f.SetRepeated(true)
-					m.AddField(f)
-					resType = m
-				} else {
-					typ, err := c.compileSchema(resName, resp.Schema)
-					if err != nil {
-						return errors.Wrapf(err, `failed to compile response for %s`, endpointName)
-					}
-					resType = typ
+				typ, err := c.compileResponseSchema(resName, resp.Schema)
+				if err != nil {
+					return errors.Wrapf(err, `failed to compile response for %s`, endpointName)
 				}
+				resType = typ
 			} else if resp.Ref != "" {
+				// a global response without a schema has no body, so
+				// the rpc keeps returning the empty type
+				if global, ok := c.spec.Responses[strings.TrimPrefix(normalizeRef(resp.Ref), "#/responses/")]; ok && global.Schema == nil {
+					break
+				}
 				typ, err := c.getTypeFromReference(resp.Ref)
 				if err != nil {
 					return errors.Wrapf(err, `failed to look up response ref for %s`, endpointName)
@@ -398,27 +1241,38 @@ func (c *compileCtx) compilePath(path string, p *openapi.Path) error {
 			}
 		}
 
+		if resType == nil && c.concreteEmptyMessages {
+			m := protobuf.NewMessage(endpointName + c.responseSuffix)
+			c.addType(m)
+			rpc.SetResponse(m)
+		}
+
+		if c.errorResponses {
+			if err := c.compileErrorResponses(endpointName, e.Responses); err != nil {
+				return errors.Wrapf(err, `failed to compile error responses for %s`, endpointName)
+			}
+		}
+
 		if c.annotate {
 			// check if we have a "in: body" parameter
 			var bodyParam string
 			for _, p := range params {
-				if p.In == "body" {
-					bodyParam = p.Name
+				if c.parameterLocation(p) == "body" {
+					bodyParam = c.parameterFieldName(p)
 					break
 				}
 			}
 
-			annotationPath := path
-			if len(c.spec.BasePath) > 0 {
-				for strings.HasPrefix(annotationPath, "/") {
-					annotationPath = annotationPath[1:]
-				}
-				annotationPath = c.spec.BasePath + "/" + annotationPath
-			}
-			a := protobuf.NewHTTPAnnotation(e.Verb, annotationPath)
+			a := protobuf.NewHTTPAnnotation(e.Verb, c.annotationPath(path, params))
 			if bodyParam != "" {
 				a.SetBody(bodyParam)
 			}
+			if e.ResponseBody != "" {
+				if err := checkResponseBody(resType, e.ResponseBody); err != nil {
+					return errors.Wrapf(err, `invalid x-grpc-response-body for %s`, endpointName)
+				}
+				a.SetResponseBody(e.ResponseBody)
+			}
 			rpc.AddOption(a)
 		}
 
@@ -426,85 +1280,629 @@ func (c *compileCtx) compilePath(path string, p *openapi.Path) error {
 			rpc.AddOption(protobuf.NewRPCOption(optName, optValue))
 		}
 
+		if c.tagsAsOption != "" && len(e.Tags) > 0 {
+			rpc.AddOption(protobuf.NewRPCOption(c.tagsAsOption, strings.Join(e.Tags, ",")))
+		}
+
+		if c.securityAsOption != "" && security != "" {
+			rpc.AddOption(protobuf.NewRPCOption(c.securityAsOption, security))
+		}
+
 		c.addRPC(rpc)
+		c.popBreadcrumb()
+	}
+	return nil
+}
+
+// checkResponseBody makes sure that the response body of the http
+// annotation names a field of the response message. Messages without
+// fields may be declared elsewhere, so they are given the benefit of
+// the doubt
+func checkResponseBody(res protobuf.Type, name string) error {
+	if res == nil {
+		return errors.New(`the endpoint has no response body`)
+	}
+	m, ok := res.(*protobuf.Message)
+	if !ok || len(m.Fields()) == 0 {
+		return nil
+	}
+
+	for _, f := range m.Fields() {
+		if f.Name() == name {
+			return nil
+		}
+	}
+	return errors.Errorf(`response message %s has no field %s`, m.Name(), name)
+}
+
+// the number of lines of an example response that are kept in the
+// comment of an rpc. see WithExamplesAsComments
+const maxExampleLines = 20
+
+// responseExampleComment returns the example of the successful response
+// of the endpoint, pretty printed and truncated, or an empty string if
+// there is none. JSON examples are preferred over the others
+func (c *compileCtx) responseExampleComment(e *openapi.Endpoint) string {
+	var example interface{}
+	for _, code := range []string{`200`, `201`} {
+		resp, ok := e.Responses[code]
+		if !ok {
+			continue
+		}
+		if resp.Ref != "" {
+			global, ok := c.spec.Responses[strings.TrimPrefix(normalizeRef(resp.Ref), "#/responses/")]
+			if !ok {
+				continue
+			}
+			resp = global
+		}
+
+		if v, ok := resp.Examples["application/json"]; ok {
+			example = v
+		} else if len(resp.Examples) > 0 {
+			var mimeTypes []string
+			for mimeType := range resp.Examples {
+				mimeTypes = append(mimeTypes, mimeType)
+			}
+			sort.Strings(mimeTypes)
+			example = resp.Examples[mimeTypes[0]]
+		} else {
+			example = resp.Example
+		}
+		break
+	}
+	if example == nil {
+		return ""
+	}
+
+	var text string
+	if s, ok := example.(string); ok {
+		text = s
+	} else {
+		b, err := json.MarshalIndent(example, "", "  ")
+		if err != nil {
+			return ""
+		}
+		text = string(b)
+	}
+
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) > maxExampleLines {
+		lines = append(lines[:maxExampleLines], "...")
+	}
+	return "Example response:\n" + strings.Join(lines, "\n")
+}
+
+// securityRequirements describes the security requirements of the
+// endpoint, or of the spec if the endpoint doesn't have any, e.g.
+// "oauth2:read,write | api_key"
+func (c *compileCtx) securityRequirements(e *openapi.Endpoint) string {
+	requirements := c.spec.Security
+	if e.Security != nil {
+		requirements = e.Security
+	}
+
+	var alternatives []string
+	for _, requirement := range requirements {
+		var schemes []string
+		for scheme, scopes := range requirement {
+			if len(scopes) > 0 {
+				scheme += ":" + strings.Join(scopes, ",")
+			}
+			schemes = append(schemes, scheme)
+		}
+		if len(schemes) == 0 {
+			continue
+		}
+		sort.Strings(schemes)
+		alternatives = append(alternatives, strings.Join(schemes, " & "))
+	}
+	return strings.Join(alternatives, " | ")
+}
+
+// fullPath returns the path prefixed with the base path of the spec
+func (c *compileCtx) fullPath(path string) string {
+	if len(c.spec.BasePath) == 0 {
+		return path
+	}
+	for strings.HasPrefix(path, "/") {
+		path = path[1:]
+	}
+	return c.spec.BasePath + "/" + path
+}
+
+// compiles the schema of a response to a message named `name`
+func (c *compileCtx) compileResponseSchema(name string, s *openapi.Schema) (protobuf.Type, error) {
+	// Wow, this *sucks*! We need to special-case when the schema
+	// is an array definition, because then we need to create
+	// a FooResponse { repeated Bar field } instead of what we
+	// do in the property definition, which is to compile the
+	// Items schema and slap a repeated on it
+	if s.Items != nil {
+		typ, err := c.compileItems(name, s.Items)
+		if err != nil {
+			return nil, errors.Wrap(err, `failed to compile array response`)
+		}
+		m := protobuf.NewMessage(name)
+		f := protobuf.NewField(typ, "items", 1)
+		f.SetRepeated(true)
+		m.AddField(f)
+		return m, nil
+	}
+
+	if isScalarSchema(s) {
+		// likewise, scalars and enums are not messages, so we
+		// create a FooResponse { Bar value }
+		m, err := c.compileValueWrapper(name, s)
+		if err != nil {
+			return nil, errors.Wrap(err, `failed to compile scalar response`)
+		}
+		return m, nil
+	}
+
+	typ, err := c.compileSchema(name, s)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := typ.(*protobuf.Message); !ok {
+		// a reference to a scalar or enum definition
+		typ, err = c.compileValueWrapper(name, s)
+		if err != nil {
+			return nil, errors.Wrap(err, `failed to compile scalar response`)
+		}
+	}
+	return typ, nil
+}
+
+// compiles the inline schemas of the responses other than 2xx into
+// messages, which are named after the description of the response,
+// e.g. FooNotFoundResponse, or after the status code if the description
+// can't be used, e.g. FooResponse404. Responses that refer to a definition
+// are already named, so they are skipped
+func (c *compileCtx) compileErrorResponses(endpointName string, responses map[string]*openapi.Response) error {
+	var codes []string
+	for code, resp := range responses {
+		if strings.HasPrefix(code, "2") || resp.Schema == nil || resp.Schema.Ref != "" {
+			continue
+		}
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	// responses with the same description are told apart by their code
+	slugs := map[string]struct{}{}
+	for _, code := range codes {
+		resp := responses[code]
+
+		name := endpointName + c.responseSuffix + camelCase(code)
+		if slug := descriptionToName(resp.Description); slug != "" {
+			if _, ok := slugs[slug]; !ok {
+				slugs[slug] = struct{}{}
+				name = endpointName + slug + c.responseSuffix
+			}
+		}
+
+		typ, err := c.compileResponseSchema(name, resp.Schema)
+		if err != nil {
+			return errors.Wrapf(err, `failed to compile response %s`, code)
+		}
+		c.addType(typ)
+	}
+	return nil
+}
+
+// returns true if an endpoint with the given tags should be compiled,
+// according to the tags specified by WithIncludeTags and WithExcludeTags
+func (c *compileCtx) isTagIncluded(tags []string) bool {
+	included := len(c.includeTags) == 0
+	for _, tag := range tags {
+		if _, ok := c.excludeTags[tag]; ok {
+			return false
+		}
+		if _, ok := c.includeTags[tag]; ok {
+			included = true
+		}
+	}
+	return included
+}
+
+// Search for type by given name. looks up from the current scope (message,
+// if applicable), all the way up to package scope
+func (c *compileCtx) getType(name string) (protobuf.Type, error) {
+	if t, ok := builtinTypes[name]; ok {
+		return t, nil
+	}
+
+	for i := len(c.parents) - 1; i >= 0; i-- {
+		parent := c.parents[i]
+		container, ok := c.types[parent]
+		if !ok {
+			continue
+		}
+
+		for t := range container {
+			if t.Name() == name {
+				return t, nil
+			}
+		}
+	}
+
+	return nil, errors.Errorf(`failed to find type %s`, name)
+}
+
+func (c *compileCtx) getBoxedType(t protobuf.Type) protobuf.Type {
+	switch t {
+	case protobuf.BoolType:
+		return protobuf.BoolValueType
+	case protobuf.BytesType:
+		return protobuf.BytesValueType
+	case protobuf.DoubleType:
+		return protobuf.DoubleValueType
+	case protobuf.FloatType:
+		return protobuf.FloatValueType
+	case protobuf.Int32Type:
+		return protobuf.Int32ValueType
+	case protobuf.Int64Type:
+		return protobuf.Int64ValueType
+	case protobuf.StringType:
+		return protobuf.StringValueType
+	case protobuf.UInt32Type:
+		return protobuf.UInt32ValueType
+	case protobuf.UInt64Type:
+		return protobuf.UInt64ValueType
+	default:
+		return t
+	}
+}
+
+// returns true if t is one of the wrapper messages of getBoxedType
+func isBoxedType(t protobuf.Type) bool {
+	switch t {
+	case protobuf.BoolValueType, protobuf.BytesValueType, protobuf.DoubleValueType,
+		protobuf.FloatValueType, protobuf.Int32ValueType, protobuf.Int64ValueType,
+		protobuf.StringValueType, protobuf.UInt32ValueType, protobuf.UInt64ValueType:
+		return true
+	}
+	return false
+}
+
+// OpenAPI 3 keeps the reusable objects under #/components, while
+// Swagger 2.0 keeps them at the top level. as components are compiled
+// along with the definitions, references to them are normalized to the
+// Swagger 2.0 form, so that both can be used in the same spec
+var componentRefPrefixes = [][2]string{
+	{"#/components/schemas/", "#/definitions/"},
+	{"#/components/parameters/", "#/parameters/"},
+	{"#/components/responses/", "#/responses/"},
+}
+
+func normalizeRef(ref string) string {
+	for _, prefix := range componentRefPrefixes {
+		if strings.HasPrefix(ref, prefix[0]) {
+			return prefix[1] + strings.TrimPrefix(ref, prefix[0])
+		}
+	}
+	return ref
+}
+
+func (c *compileCtx) getTypeFromReference(ref string) (protobuf.Type, error) {
+	ref = normalizeRef(ref)
+	if t, ok := knownDefinitions[ref]; ok {
+		return t, nil
+	}
+
+	if t, ok := c.externalDefinition(ref); ok {
+		return t, nil
+	}
+
+	if name, ok := c.excludedDefinition(ref); ok {
+		return nil, errors.Errorf(`reference %s refers to definition %s, which is excluded`, ref, name)
+	}
+
+	if t, ok := c.definitions[ref]; ok {
+		return c.followReferences(ref, t)
+	}
+
+	return nil, errors.Errorf(`reference %s could not be resolved`, ref)
+}
+
+// followReferences returns the type that the definition `ref` stands
+// for. A definition that merely refers to another one that was not
+// compiled yet is compiled to a Reference, which is followed here, as
+// nothing else resolves it. A chain of such definitions that leads back
+// to where it started, e.g. A: {$ref: B} and B: {$ref: A}, never ends
+// in a type
+func (c *compileCtx) followReferences(ref string, t protobuf.Type) (protobuf.Type, error) {
+	chain := []string{ref}
+	for {
+		r, ok := t.(*protobuf.Reference)
+		if !ok {
+			return t, nil
+		}
+
+		next := normalizeRef(r.Name())
+		for _, seen := range chain {
+			if seen == next {
+				return nil, errors.Errorf(`reference cycle %s`, strings.Join(append(chain, next), " -> "))
+			}
+		}
+		chain = append(chain, next)
+
+		t, ok = c.definitions[next]
+		if !ok {
+			return nil, errors.Errorf(`reference %s could not be resolved`, next)
+		}
+	}
+}
+
+// externalDefinition returns the message that ref refers to, if it
+// refers to a message in a Protocol Buffers file, e.g. common.proto#/Address.
+// The message is referred to by its fully qualified name, which is
+// either given as is, e.g. common.proto#/foo.v1.Address, or assumed to
+// be in a package named after the file, i.e. common.Address
+func (c *compileCtx) externalDefinition(ref string) (protobuf.Type, bool) {
+	i := strings.Index(ref, "#/")
+	if i <= 0 || !strings.HasSuffix(ref[:i], ".proto") {
+		return nil, false
+	}
+
+	lib, name := ref[:i], ref[i+2:]
+	if name == "" {
+		return nil, false
+	}
+
+	types, ok := c.externalDefinitions[lib]
+	if !ok {
+		types = map[string]protobuf.Type{}
+		c.externalDefinitions[lib] = types
+	}
+	if t, ok := types[name]; ok {
+		return t, true
+	}
+
+	typeName := name
+	if strings.IndexByte(name, '.') < 0 {
+		pkg := strings.TrimSuffix(path.Base(lib), ".proto")
+		typeName = packageName(pkg) + "." + name
+	}
+
+	t := protobuf.NewMessage(typeName)
+	types[name] = t
+	return t, true
+}
+
+// excludedDefinition returns the name of the definition that ref points
+// into, if that definition is excluded by WithExcludeDefinitions
+func (c *compileCtx) excludedDefinition(ref string) (string, bool) {
+	ref = strings.TrimPrefix(ref, requestRefPrefix)
+	if !strings.HasPrefix(ref, "#/definitions/") {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(ref, "#/definitions/")
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		name = name[:i]
+	}
+	_, ok := c.excludeDefinitions[name]
+	return name, ok
+}
+
+func (c *compileCtx) compileEnum(name string, s *openapi.Schema) (*protobuf.Enum, error) {
+	var prefix bool
+	if c.parent() != c.pkg || c.prefixEnums {
+		prefix = true
+	}
+	if v := c.schemaTitle(s); v != "" {
+		name = v
+	}
+	if c.topLevelEnums {
+		name = c.topLevelEnumName(camelCase(name))
+	}
+
+	typeName := camelCase(name)
+	if v := s.ProtoEnumName; v != "" {
+		name = v
+		typeName = v
+	}
+	// a hoisted enum would silently replace the definition it is named
+	// after, whichever is compiled first
+	if c.topLevelEnums && c.parent() != c.pkg && c.isDefinitionName(typeName) {
+		return nil, errors.Errorf(`enum %s declared in %s conflicts with the definition of the same name`, typeName, c.parent().Name())
+	}
+	// two hoisted enums may end up with the same name, e.g. Pet.typeStatus
+	// and PetType.status, in which case one would replace the other
+	if c.topLevelEnums && c.parent() != c.pkg {
+		if other, ok := c.hoistedEnums[typeName]; ok && other.parent != c.parent().Name() && other.schema != s {
+			return nil, errors.Errorf(`enum %s declared in %s conflicts with the enum of the same name declared in %s`, typeName, c.parent().Name(), other.parent)
+		}
+		c.hoistedEnums[typeName] = hoistedEnum{parent: c.parent().Name(), schema: s}
+	}
+
+	e := protobuf.NewEnum(typeName)
+
+	if len(s.EnumVarNames) > 0 && len(s.EnumVarNames) != len(s.Enum) {
+		return nil, errors.Errorf(`x-enum-varnames of enum %s lists %d names for %d values`, typeName, len(s.EnumVarNames), len(s.Enum))
+	}
+
+	// the values of integer enums are used as the numbers of the
+	// enum elements. proto3 requires the first element to be zero, so
+	// one is added if none of the values is zero
+	numbers, ok := enumNumbers(s)
+	seen := map[string]struct{}{}
+	if ok && !hasZero(numbers) {
+		elem := protobuf.NewEnumElement(uniqueEnumElementName(seen, c.enumNames.convert(name+"_unspecified")))
+		elem.SetNumber(0)
+		e.AddElement(elem)
+	}
+
+	numeric := s.Type.Contains("integer") || s.Type.Contains("number")
+	var zero *protobuf.EnumElement
+	for i, enum := range s.Enum {
+		ename := enum
+		// x-enum-varnames lists the name of each value
+		if i < len(s.EnumVarNames) {
+			ename = s.EnumVarNames[i]
+		} else if numeric {
+			// names can't start with a digit, and neither the sign nor the
+			// decimal point of numeric values can be expressed in a name
+			ename = strings.Replace(ename, "-", "minus_", 1)
+			ename = strings.Replace(ename, ".", "_", -1)
+		}
+		// the value can't be told from the name when the name spells
+		// it differently, e.g. NY_REGION for "N.Y. / Region", so the
+		// value is kept in the comment
+		changed := !numeric && !strings.EqualFold(normalizeEnumName(ename), enum)
+		if prefix || startsWithDigit(ename) {
+			ename = name + "_" + ename
+		}
+		converted := c.enumNames.convert(ename)
+		unique := uniqueEnumElementName(seen, converted)
+		elem := protobuf.NewEnumElement(unique)
+		var comment []string
+		// x-enum-descriptions lists the description of each value
+		if i < len(s.EnumDescriptions) {
+			if v := strings.TrimSpace(s.EnumDescriptions[i]); v != "" {
+				comment = append(comment, v)
+			}
+		}
+		if !numeric && (changed || unique != converted) {
+			comment = append(comment, fmt.Sprintf("original: %q", enum))
+		}
+		elem.SetComment(strings.Join(comment, "\n"))
+		if ok {
+			elem.SetNumber(numbers[i])
+			if numbers[i] == 0 && i > 0 {
+				// moved to the front below
+				zero = elem
+				continue
+			}
+		}
+		e.AddElement(elem)
+	}
+	if zero != nil {
+		e.PrependElement(zero)
+	}
+	return e, nil
+}
+
+// values that differ only in case or punctuation, e.g. "active" and
+// "Active", are normalized to the same name. a suffix is appended to the
+// names that are already taken in the enum, so that the elements are
+// all declared (ACTIVE, ACTIVE_2, ...)
+func uniqueEnumElementName(seen map[string]struct{}, name string) string {
+	unique := name
+	for i := 2; ; i++ {
+		if _, ok := seen[unique]; !ok {
+			break
+		}
+		unique = name + "_" + strconv.Itoa(i)
+	}
+	seen[unique] = struct{}{}
+	return unique
+}
+
+// mapType consults the type mapper given by WithTypeMapper, if any.
+// Messages and enums returned by the mapper are declared in the
+// package, once no matter how many times they are returned
+func (c *compileCtx) mapType(name string, s *openapi.Schema) (protobuf.Type, bool, error) {
+	if c.typeMapper == nil {
+		return nil, false, nil
+	}
+
+	t, ok, err := c.typeMapper(name, s)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if t == nil {
+		return nil, false, errors.New(`type mapper returned a nil type`)
+	}
+
+	switch t.(type) {
+	case *protobuf.Message, *protobuf.Enum:
+		c.addTypeToParent(t, c.pkg)
+	default:
+		c.addImportForType(t.Name())
 	}
-	return nil
+	return t, true, nil
 }
 
-// Search for type by given name. looks up from the current scope (message,
-// if applicable), all the way up to package scope
-func (c *compileCtx) getType(name string) (protobuf.Type, error) {
-	if t, ok := builtinTypes[name]; ok {
-		return t, nil
+// schemaTitle returns the camel cased title of the schema, which is used
+// as the name of the generated message or enum when WithUseSchemaTitle
+// is specified. An empty string is returned otherwise
+func (c *compileCtx) schemaTitle(s *openapi.Schema) string {
+	if !c.useSchemaTitle {
+		return ""
 	}
+	return camelCase(s.Title)
+}
 
+// enums that would be nested in messages are declared in the package
+// when WithTopLevelEnums is specified, so the names of the enclosing
+// messages are prepended to the name of the enum to keep them apart,
+// unless the name already starts with them (e.g. PetStatus in Pet, but
+// not Petal)
+func (c *compileCtx) topLevelEnumName(name string) string {
 	for i := len(c.parents) - 1; i >= 0; i-- {
-		parent := c.parents[i]
-		container, ok := c.types[parent]
-		if !ok {
+		p := c.parents[i]
+		if p == c.pkg {
 			continue
 		}
-
-		for t := range container {
-			if t.Name() == name {
-				return t, nil
-			}
+		if !hasWordPrefix(name, p.Name()) {
+			name = p.Name() + name
 		}
 	}
-
-	return nil, errors.Errorf(`failed to find type %s`, name)
+	return name
 }
 
-func (c *compileCtx) getBoxedType(t protobuf.Type) protobuf.Type {
-	switch t {
-	case protobuf.BoolType:
-		return protobuf.BoolValueType
-	case protobuf.BytesType:
-		return protobuf.BytesValueType
-	case protobuf.DoubleType:
-		return protobuf.DoubleValueType
-	case protobuf.FloatType:
-		return protobuf.FloatValueType
-	case protobuf.Int32Type:
-		return protobuf.Int32ValueType
-	case protobuf.Int64Type:
-		return protobuf.Int64ValueType
-	case protobuf.StringType:
-		return protobuf.StringValueType
-	default:
-		return t
+// hasWordPrefix returns true if the camel cased name starts with the
+// words of prefix, i.e. prefix is followed by the start of a word
+func hasWordPrefix(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
 	}
+	rest := name[len(prefix):]
+	return rest == "" || unicode.IsUpper(rune(rest[0])) || unicode.IsDigit(rune(rest[0]))
 }
 
-func (c *compileCtx) getTypeFromReference(ref string) (protobuf.Type, error) {
-	if t, ok := knownDefinitions[ref]; ok {
-		return t, nil
-	}
-
-	if t, ok := c.definitions[ref]; ok {
-		return t, nil
+// isDefinitionName returns true if a definition is declared in the
+// package under the given name, whether or not it was compiled yet
+func (c *compileCtx) isDefinitionName(name string) bool {
+	for key, s := range c.spec.Definitions {
+		if _, ok := c.excludeDefinitions[key]; ok {
+			continue
+		}
+		if camelCase(key) == name || s.ProtoMessageName == name || s.ProtoEnumName == name || c.schemaTitle(s) == name {
+			return true
+		}
 	}
-
-	return nil, errors.Errorf(`reference %s could not be resolved`, ref)
+	return false
 }
 
-func (c *compileCtx) compileEnum(name string, elements []string) (*protobuf.Enum, error) {
-	var prefix bool
-	if c.parent() != c.pkg || c.prefixEnums {
-		prefix = true
+// returns the values of an integer enum as numbers. false is returned
+// if the schema isn't an integer enum, or a value is out of range
+func enumNumbers(s *openapi.Schema) ([]int, bool) {
+	if !s.Type.Contains("integer") {
+		return nil, false
 	}
 
-	e := protobuf.NewEnum(camelCase(name))
-	for _, enum := range elements {
-		ename := enum
-		if prefix || looksLikeInteger(ename) {
-			ename = name + "_" + ename
+	numbers := make([]int, len(s.Enum))
+	for i, v := range s.Enum {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return nil, false
 		}
-		ename = normalizeEnumName(ename)
+		numbers[i] = int(n)
+	}
+	return numbers, true
+}
 
-		e.AddElement(allCaps(ename))
+func hasZero(numbers []int) bool {
+	for _, n := range numbers {
+		if n == 0 {
+			return true
+		}
 	}
-	return e, nil
+	return false
 }
 
 func (c *compileCtx) compileSchemaMultiType(name string, s *openapi.Schema) (protobuf.Type, error) {
@@ -521,6 +1919,8 @@ func (c *compileCtx) compileSchemaMultiType(name string, s *openapi.Schema) (pro
 	// 1. non-nullable fields with multiple types
 	// 2. has no type
 	if (!hasNull || len(types) > 1) || len(types) == 0 {
+		c.warnf(`type [%s] can't be expressed in protobuf, compiled to google.protobuf.Any`, strings.Join(s.Type, ", "))
+		c.addImportForType("google.protobuf.Any")
 		return c.getType("google.protobuf.Any")
 	}
 
@@ -537,16 +1937,16 @@ func (c *compileCtx) compileMap(name string, rawName string, s *openapi.Schema)
 	switch {
 	case s.Ref != "":
 		var err error
-		typ, err = c.compileReferenceSchema(name, s)
+		typ, err = c.compileNestedReference(name, s)
 		if err != nil {
 			return nil, errors.Wrapf(err, `failed to compile reference %s`, s.Ref)
 		}
-	case !s.Type.Empty():
+	case !s.Type.Empty() && s.Type.First() != "object":
 		var err error
 		if s.Type.First() == "array" && s.Items != nil {
 			if s.Items.Ref != "" {
 				// reference schema for array items
-				baseFieldName := camelCase(strings.TrimPrefix(s.Items.Ref, "#/definitions"))
+				baseFieldName := camelCase(strings.TrimPrefix(normalizeRef(s.Items.Ref), "#/definitions"))
 				typ = c.createListWrapper(name, rawName, baseFieldName, s)
 				// finally, make sure that this type is registered, if need be.
 				// hack to prevent duplicate top-level wrapper messages
@@ -564,9 +1964,12 @@ func (c *compileCtx) compileMap(name string, rawName string, s *openapi.Schema)
 				typ = c.createListWrapper(name, rawName, baseFieldName, s)
 				// finally, make sure that this type is registered, if need be.
 				c.addType(typ)
+				depth := len(c.breadcrumbs)
 				subtyp, err := c.compileSchema(name, s.Items)
 				if err == nil {
 					c.addType(subtyp)
+				} else {
+					c.truncateBreadcrumbs(depth)
 				}
 			} else {
 				return nil, errors.Errorf(`An array for map types must specify a reference or an object`)
@@ -576,6 +1979,7 @@ func (c *compileCtx) compileMap(name string, rawName string, s *openapi.Schema)
 			if err != nil {
 				return nil, errors.Wrapf(err, `failed to get type %s`, s.Type)
 			}
+			typ = c.applyBuiltinFormat(typ, s.Format)
 		}
 	default:
 		var err error
@@ -589,8 +1993,112 @@ func (c *compileCtx) compileMap(name string, rawName string, s *openapi.Schema)
 
 }
 
+// isMapSchema returns true if the schema is compiled to a map, i.e. an
+// object without properties whose additionalProperties have a schema
+func isMapSchema(s *openapi.Schema) bool {
+	if s.Ref != "" || len(s.Properties) > 0 || len(s.AllOf) > 0 {
+		return false
+	}
+	if !s.Type.Empty() && !s.Type.Contains("object") {
+		return false
+	}
+	ap := s.AdditionalProperties
+	return ap != nil && !ap.IsNil() && (ap.Type != nil || ap.Ref != "")
+}
+
+// compileItems compiles the items of an array, which can't be maps
+func (c *compileCtx) compileItems(name string, s *openapi.Schema) (protobuf.Type, error) {
+	if s.Ref != "" {
+		return c.compileNestedReference(name, s)
+	}
+	return c.compileSchema(name, s)
+}
+
+// compileNestedReference compiles a reference used as the value of a
+// map or as the items of an array. Fields that refer to a map
+// definition use the map as is, but maps can be neither the values of
+// other maps nor repeated. For those, a message named after the
+// definition, which holds the map in a single "value" field, is
+// declared instead
+func (c *compileCtx) compileNestedReference(name string, s *openapi.Schema) (protobuf.Type, error) {
+	ref := normalizeRef(s.Ref)
+	defName := strings.TrimPrefix(ref, "#/definitions/")
+	def, ok := c.spec.Definitions[defName]
+	if !ok || !strings.HasPrefix(ref, "#/definitions/") || !isMapSchema(def) {
+		return c.compileReferenceSchema(name, s)
+	}
+	if _, excluded := c.excludeDefinitions[defName]; excluded {
+		return c.compileReferenceSchema(name, s)
+	}
+
+	if m, ok := c.mapWrappers[ref]; ok {
+		return m, nil
+	}
+
+	wrapperName := camelCase(defName)
+	if v := def.ProtoMessageName; v != "" {
+		wrapperName = v
+	}
+	m := protobuf.NewMessage(wrapperName)
+	m.SetComment(makeComment(schemaComment(def), "automatically generated wrapper for the "+camelCase(defName)+" map"))
+	// registered first, so that maps that contain themselves refer
+	// to the wrapper being compiled
+	c.mapWrappers[ref] = m
+
+	c.pushParent(m)
+	typ, err := c.compileMap("Value", "Value", def.AdditionalProperties)
+	c.popParent()
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to compile value of %s`, wrapperName)
+	}
+	c.addImportForType(typ.Name())
+	m.AddField(protobuf.NewField(typ, "value", 1))
+	c.addTypeToParent(m, c.pkg)
+	return m, nil
+}
+
+// name of the field that holds the additional properties of objects
+// that also declare properties
+const additionalPropertiesFieldName = "additional_properties"
+
+// adds a catch-all map field for the additional properties of an object
+// to the message compiled from its declared properties. The field is
+// numbered after the declared fields
+func (c *compileCtx) compileAdditionalProperties(m *protobuf.Message, ap *openapi.Schema) error {
+	index := 1
+	for _, f := range m.Fields() {
+		if f.Name() == additionalPropertiesFieldName {
+			return errors.Errorf(`property %s conflicts with the field for additionalProperties`, f.Name())
+		}
+		if f.Index() >= index {
+			index = f.Index() + 1
+		}
+	}
+
+	var typ protobuf.Type
+	if ap.Type == nil && ap.Ref == "" {
+		// additionalProperties: true or additionalProperties: {}
+		typ = protobuf.StructType
+	} else {
+		var err error
+		typ, err = c.compileMap(additionalPropertiesFieldName+"Message", additionalPropertiesFieldName, ap)
+		if err != nil {
+			return errors.Wrap(err, `failed to compile map`)
+		}
+	}
+
+	f := protobuf.NewField(typ, additionalPropertiesFieldName, index)
+	if v := schemaComment(ap); len(v) > 0 {
+		f.SetComment(v)
+	}
+	c.addImportForType(typ.Name())
+	m.AddField(f)
+	return nil
+}
+
 func (c *compileCtx) compileReferenceSchema(name string, s *openapi.Schema) (protobuf.Type, error) {
-	m, err := c.getTypeFromReference(s.Ref)
+	ref := c.requestRef(normalizeRef(s.Ref))
+	m, err := c.getTypeFromReference(ref)
 	if err == nil {
 		return m, nil
 	}
@@ -601,9 +2109,10 @@ func (c *compileCtx) compileReferenceSchema(name string, s *openapi.Schema) (pro
 	//
 	// if it's the former, then we can tolorate this error, and return
 	// a "promise" to be fulfilled at a later time. Otherwise, it's a
-	// fatal error.
-	if c.phase == phaseCompileDefinitions {
-		r := protobuf.NewReference(s.Ref)
+	// fatal error. References to excluded definitions can never be
+	// fulfilled, though
+	if _, excluded := c.excludedDefinition(ref); c.phase == phaseCompileDefinitions && !excluded {
+		r := protobuf.NewReference(ref)
 		return r, nil
 	}
 	return nil, errors.Wrapf(err, `failed to resolve reference %s`, s.Ref)
@@ -618,6 +2127,20 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 		return m, nil
 	}
 
+	if t, ok, err := c.mapType(name, s); err != nil {
+		return nil, errors.Wrapf(err, `failed to map type of %s`, name)
+	} else if ok {
+		return t, nil
+	}
+
+	// protobuf has no way to say what a value must not be, so anything
+	// goes. see notComment
+	if s.Not != nil {
+		c.warnf("`not` can't be expressed in protobuf, compiled to google.protobuf.Any")
+		c.addImportForType("google.protobuf.Any")
+		return c.getType("google.protobuf.Any")
+	}
+
 	if len(s.AllOf) > 0 {
 		if len(s.AllOf) > 1 {
 			return nil, errors.New("allOf with multiple values is not supported")
@@ -634,6 +2157,14 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 
 	rawName := name
 	name = camelCase(name)
+	if v := c.schemaTitle(s); v != "" {
+		rawName = v
+		name = v
+	}
+	if v := s.ProtoMessageName; v != "" {
+		rawName = v
+		name = v
+	}
 	// could be a builtin... try as-is once, then the camel cased
 	for _, n := range []string{rawName, name} {
 		if v, err := c.getType(n); err == nil {
@@ -651,7 +2182,9 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 
 	switch {
 	case s.Type.Empty() || s.Type.Contains("object"):
-		if ap := s.AdditionalProperties; ap != nil && !ap.IsNil() {
+		ap := s.AdditionalProperties
+		hasAdditionalProperties := ap != nil && !ap.IsNil()
+		if hasAdditionalProperties && len(s.Properties) == 0 {
 			// if the spec has additionalProperties: true or additionalProperties: {}, use Struct as the type
 			if ap.Type == nil && ap.Ref == "" {
 				c.addImportForType(protobuf.StructType.Name())
@@ -661,16 +2194,36 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 			}
 		}
 
+		// an object without properties may hold arbitrary JSON
+		if c.emptyObjectAsStruct && ap == nil && len(s.Properties) == 0 && s.Type.Contains("object") {
+			c.addImportForType(protobuf.StructType.Name())
+			return protobuf.StructType, nil
+		}
+
 		m := protobuf.NewMessage(name)
-		if len(s.Description) > 0 {
-			m.SetComment(s.Description)
+		comment := schemaComment(s)
+		if c.additionalPropertiesComment && ap != nil && ap.IsNil() {
+			// additionalProperties: false
+			comment = makeComment(comment, "additional properties not allowed")
+		}
+		if len(comment) > 0 {
+			m.SetComment(comment)
+		}
+		for optName, optValue := range s.ProtoMessageOptions {
+			m.AddOption(optName, optValue)
 		}
 
 		c.pushParent(m)
-		if err := c.compileSchemaProperties(m, s.Properties); err != nil {
+		if err := c.compileSchemaProperties(m, s.Properties, s.Required); err != nil {
 			c.popParent()
 			return nil, errors.Wrapf(err, `failed to compile properties for %s`, name)
 		}
+		if hasAdditionalProperties {
+			if err := c.compileAdditionalProperties(m, ap); err != nil {
+				c.popParent()
+				return nil, errors.Wrapf(err, `failed to compile additional properties for %s`, name)
+			}
+		}
 		c.popParent()
 
 		c.addType(m)
@@ -679,7 +2232,7 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 	case s.Type.Contains("array"):
 		// if it's an array, we need to compile the "items" field
 		// but ignore the comments
-		m, err := c.compileSchema(name, s.Items)
+		m, err := c.compileItems(name, s.Items)
 		if err != nil {
 			return nil, errors.Wrap(err, `failed to compile items field of the schema`)
 		}
@@ -688,7 +2241,7 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 	case s.Type.Contains("string") || s.Type.Contains("integer") || s.Type.Contains("number") || s.Type.Contains("boolean"):
 		if len(s.Enum) > 0 {
 			name = strings.TrimSuffix(name, "Message")
-			t, err := c.compileEnum(name, s.Enum)
+			t, err := c.compileEnum(name, s)
 			if err != nil {
 				return nil, errors.Wrap(err, `failed to compile enum field of the schema`)
 			}
@@ -713,39 +2266,141 @@ func (c *compileCtx) compileSchema(name string, s *openapi.Schema) (protobuf.Typ
 	}
 }
 
-func (c *compileCtx) compileSchemaProperties(m *protobuf.Message, props map[string]*openapi.Schema) error {
+func (c *compileCtx) compileSchemaProperties(m *protobuf.Message, props map[string]*openapi.Schema, required []string) error {
 	var fields []struct {
-		comment  string
-		index    int
-		name     string
-		repeated bool
-		typ      protobuf.Type
-	}
+		comment      string
+		defaultValue interface{}
+		deprecated   bool
+		index        int
+		jsonName     string
+		name         string
+		omit         bool
+		packed       *bool
+		repeated     bool
+		required     bool
+		typ          protobuf.Type
+	}
+
+	isRequired := map[string]struct{}{}
+	for _, name := range required {
+		isRequired[name] = struct{}{}
+	}
+
+	var propNames []string
+	for propName := range props {
+		propNames = append(propNames, propName)
+	}
+	sort.Strings(propNames)
+
+	for _, propName := range propNames {
+		prop := props[propName]
+		// read-only properties are never sent by the client, and
+		// write-only properties are never sent by the server. they are
+		// still numbered, so that both variants use the same numbers
+		if c.splitReadWriteOnly && ((c.inRequest && prop.ReadOnly) || (!c.inRequest && prop.WriteOnly)) {
+			fields = append(fields, struct {
+				comment      string
+				defaultValue interface{}
+				deprecated   bool
+				index        int
+				jsonName     string
+				name         string
+				omit         bool
+				packed       *bool
+				repeated     bool
+				required     bool
+				typ          protobuf.Type
+			}{
+				index: int(prop.ProtoTag),
+				name:  propName,
+				omit:  true,
+			})
+			continue
+		}
 
-	for propName, prop := range props {
 		// remove the comment so that we don't duplicate it in the
 		// field section
 		var copy openapi.Schema
 		copy = *prop
 		copy.Description = ""
-
+		copy.ProtoComment = ""
+
+		// while compiling definitions, keep track of the JSON pointer to
+		// this property, so that references to it can be resolved
+		var path string
+		if l := len(c.refPaths); l > 0 {
+			path = c.refPaths[l-1] + "/properties/" + propName
+			c.refPaths = append(c.refPaths, path)
+		}
+		c.pushBreadcrumb("property " + propName)
+		if keywords := validationKeywords(prop); len(keywords) > 0 {
+			c.warnf(`validation keywords %s are ignored`, strings.Join(keywords, ", "))
+		}
 		name, typ, index, repeated, err := c.compileProperty(propName, &copy)
+		if path != "" {
+			c.refPaths = c.refPaths[:len(c.refPaths)-1]
+		}
 		if err != nil {
 			return errors.Wrapf(err, `failed to compile property %s`, propName)
 		}
+		if path != "" && !repeated {
+			switch typ.(type) {
+			case *protobuf.Message, *protobuf.Enum:
+				c.addDefinition(path, typ)
+			}
+		}
+		// default values can only be expressed for scalar types
+		var defaultValue interface{}
+		if _, ok := typ.(protobuf.Builtin); ok {
+			defaultValue = prop.Default
+		}
+
+		// parameters are keyed by their snake cased name, and keep
+		// their original name as ProtoName
+		jsonName := propName
+		if prop.ProtoName != "" {
+			jsonName = prop.ProtoName
+		}
+
+		// packing can only be controlled for repeated scalar values.
+		// with WithWrapPrimitives the values are messages, which are
+		// never packed, so the option is dropped for wrapped numbers
+		// and booleans
+		packed := prop.ProtoPacked
+		if packed != nil && repeated && isBoxedType(typ) && typ != protobuf.StringValueType && typ != protobuf.BytesValueType {
+			c.warnf(`x-proto-packed is ignored, as the items are wrapped in %s, which can't be packed`, typ.Name())
+			packed = nil
+		}
+		if packed != nil && !(repeated && isPackable(typ)) {
+			return errors.Errorf(`x-proto-packed can only be used for arrays of numbers, booleans or enums (property %s)`, propName)
+		}
+
+		_, required := isRequired[propName]
 		fields = append(fields, struct {
-			comment  string
-			index    int
-			name     string
-			repeated bool
-			typ      protobuf.Type
+			comment      string
+			defaultValue interface{}
+			deprecated   bool
+			index        int
+			jsonName     string
+			name         string
+			omit         bool
+			packed       *bool
+			repeated     bool
+			required     bool
+			typ          protobuf.Type
 		}{
-			comment:  prop.Description,
-			index:    index,
-			name:     name,
-			repeated: repeated,
-			typ:      typ,
+			comment:      deprecatedComment(prop, makeComment(makeComment(schemaComment(prop), notComment(prop)), exampleComment(prop))),
+			defaultValue: defaultValue,
+			deprecated:   prop.Deprecated,
+			index:        index,
+			jsonName:     jsonName,
+			name:         name,
+			packed:       packed,
+			repeated:     repeated,
+			required:     required,
+			typ:          typ,
 		})
+		c.popBreadcrumb()
 	}
 
 	sort.Slice(fields, func(i, j int) bool {
@@ -756,22 +2411,90 @@ func (c *compileCtx) compileSchemaProperties(m *protobuf.Message, props map[stri
 		return fields[i].index == 0
 	})
 
+	// explicitly numbered fields must not collide with each other, and
+	// their numbers must not be handed out to the rest of the fields.
+	// neither may the numbers and names of fields that were removed
 	var taken = map[int]struct{}{}
-	serial := 1
+	explicit := map[int]string{}
+	reservedNames := map[string]struct{}{}
+	for _, removed := range c.spec.ProtoHistory[m.Name()] {
+		if removed.Number != 0 {
+			taken[removed.Number] = struct{}{}
+			m.AddReservedNumber(removed.Number)
+		}
+		if removed.Name != "" {
+			reservedNames[removed.Name] = struct{}{}
+			m.AddReservedName(removed.Name)
+		}
+	}
+	// names given with x-proto-field-name may also collide
+	names := map[string]string{}
+	for _, field := range fields {
+		if field.omit {
+			continue
+		}
+		name := c.fieldNames.convert(field.name)
+		if other, ok := names[name]; ok {
+			props := []string{other, field.jsonName}
+			sort.Strings(props)
+			return errors.Errorf(`properties %s and %s of message %s are both compiled to field %s`, props[0], props[1], m.Name(), name)
+		}
+		names[name] = field.jsonName
+	}
+
+	for _, field := range fields {
+		if _, ok := reservedNames[c.fieldNames.convert(field.name)]; ok && !field.omit {
+			return errors.Errorf(`field %s in message %s uses the name of a removed field`, field.name, m.Name())
+		}
+		if field.index == 0 {
+			continue
+		}
+		if other, ok := explicit[field.index]; ok {
+			names := []string{other, field.name}
+			sort.Strings(names)
+			return errors.Errorf(`fields %s and %s in message %s both use tag %d`, names[0], names[1], m.Name(), field.index)
+		}
+		if _, ok := taken[field.index]; ok {
+			return errors.Errorf(`field %s in message %s uses tag %d, which belonged to a removed field`, field.name, m.Name(), field.index)
+		}
+		explicit[field.index] = field.name
+		taken[field.index] = struct{}{}
+	}
+
+	serial := c.fieldNumberBase
 	for _, field := range fields {
 		index := field.index
 		if index == 0 {
-			for _, ok := taken[serial]; ok; _, ok = taken[serial] {
+			for _, ok := taken[serial]; ok || isReservedFieldNumber(serial); _, ok = taken[serial] {
 				serial++
 			}
 			index = serial
 			taken[index] = struct{}{}
 		}
+		if index > maxFieldNumber {
+			return errors.Errorf(`invalid number %d for field %s in message %s: must be at most %d`, index, field.name, m.Name(), maxFieldNumber)
+		}
 
-		f := protobuf.NewField(field.typ, normalizeFieldName(field.name), index)
+		if field.omit {
+			continue
+		}
+
+		f := protobuf.NewField(field.typ, c.fieldNames.convert(field.name), index)
 		if field.repeated {
 			f.SetRepeated(true)
 		}
+		if field.packed != nil {
+			f.AddOption("packed", *field.packed)
+		}
+		if field.deprecated {
+			f.AddOption("deprecated", true)
+		}
+		// keep the original property name for the JSON mapping
+		if c.jsonNameOption && field.jsonName != f.Name() {
+			f.SetJSONName(field.jsonName)
+		}
+		f.SetRequired(field.required)
+		f.SetDefault(field.defaultValue)
 
 		if v := field.comment; len(v) > 0 {
 			f.SetComment(v)
@@ -784,6 +2507,40 @@ func (c *compileCtx) compileSchemaProperties(m *protobuf.Message, props map[stri
 	return nil
 }
 
+// deprecatedComment marks the comment of a deprecated property the way
+// Go doc comments do, so that code generators can surface it
+func deprecatedComment(s *openapi.Schema, comment string) string {
+	if !s.Deprecated {
+		return comment
+	}
+	if comment == "" {
+		return "Deprecated: do not use."
+	}
+	return "Deprecated: " + comment
+}
+
+var qualifiedName = regexp.MustCompile(`^\.?[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// isQualifiedName returns true if s is a legal name for a message,
+// optionally qualified with its package, e.g. google.protobuf.Empty
+func isQualifiedName(s string) bool {
+	return qualifiedName.MatchString(s)
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isIdentifier returns true if s is a legal name for an rpc, a message
+// or a field, which can't be qualified
+func isIdentifier(s string) bool {
+	return identifier.MatchString(s)
+}
+
+// field numbers 19000 through 19999 are reserved for the protobuf
+// implementation, so they are never assigned automatically
+func isReservedFieldNumber(n int) bool {
+	return n >= 19000 && n <= 19999
+}
+
 func (c *compileCtx) applyBuiltinFormat(t protobuf.Type, f string) (rt protobuf.Type) {
 	switch t.Name() {
 	case "bytes":
@@ -793,15 +2550,26 @@ func (c *compileCtx) applyBuiltinFormat(t protobuf.Type, f string) (rt protobuf.
 	case "null":
 		return protobuf.NullValueType
 	case "string":
-		if f == "byte" {
+		switch f {
+		case "byte", "binary", "base64":
 			return protobuf.BytesType
 		}
+		if c.wellKnownTypes {
+			switch f {
+			case "date-time":
+				return protobuf.TimestampType
+			case "duration":
+				return protobuf.DurationType
+			}
+		}
 		return protobuf.StringType
 	case "pseudo:integer":
-		if f == "int64" {
-			return protobuf.Int64Type
+		// a format that names a protobuf scalar type is used verbatim,
+		// so that the wire type can be picked in the spec
+		if isNumericType(f) {
+			return numericTypes[f]
 		}
-		return protobuf.Int32Type
+		return numericTypes[c.defaultIntegerType]
 	case "pseudo:float":
 		return protobuf.FloatType
 	case "pseudo:number":
@@ -821,6 +2589,40 @@ func (c *compileCtx) applyBuiltinFormat(t protobuf.Type, f string) (rt protobuf.
 	return t
 }
 
+var numericTypes = map[string]protobuf.Type{
+	"double":   protobuf.DoubleType,
+	"fixed32":  protobuf.Fixed32Type,
+	"fixed64":  protobuf.Fixed64Type,
+	"float":    protobuf.FloatType,
+	"int32":    protobuf.Int32Type,
+	"int64":    protobuf.Int64Type,
+	"sfixed32": protobuf.SFixed32Type,
+	"sfixed64": protobuf.SFixed64Type,
+	"sint32":   protobuf.SInt32Type,
+	"sint64":   protobuf.SInt64Type,
+	"uint32":   protobuf.UInt32Type,
+	"uint64":   protobuf.UInt64Type,
+}
+
+// isNumericType returns true if s is the name of a numeric protobuf
+// scalar type
+func isNumericType(s string) bool {
+	_, ok := numericTypes[s]
+	return ok
+}
+
+func isPackable(t protobuf.Type) bool {
+	switch t {
+	case protobuf.StringType, protobuf.BytesType:
+		return false
+	}
+	switch t.(type) {
+	case protobuf.Builtin, *protobuf.Enum:
+		return true
+	}
+	return false
+}
+
 // compiles a single property to a field.
 // local-scoped messages are handled in the compilation for the field type.
 func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string, protobuf.Type, int, bool, error) {
@@ -831,7 +2633,21 @@ func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string,
 
 	var typName = name + "Message"
 
-	if prop.Type.Len() > 1 {
+	mapped, ok, err := c.mapType(typName, prop)
+	if err != nil {
+		return "", nil, index, false, errors.Wrapf(err, `failed to map type of property %s`, name)
+	}
+
+	if ok {
+		typ = mapped
+	} else if prop.Not != nil {
+		// see notComment
+		c.warnf("`not` can't be expressed in protobuf, compiled to google.protobuf.Any")
+		typ, err = c.getType("google.protobuf.Any")
+		if err != nil {
+			return "", nil, index, false, errors.Wrapf(err, `failed to compile property %s with not`, name)
+		}
+	} else if prop.Type.Len() > 1 {
 		typ, err = c.compileSchemaMultiType(typName, prop)
 		if err != nil {
 			return "", nil, index, false, errors.Wrap(err, `failed to compile schema with multiple types`)
@@ -844,11 +2660,26 @@ func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string,
 				return "", nil, index, false, errors.Wrapf(err, `failed to compile object property %s`, name)
 			}
 			typ = child
+		case prop.Type.Contains("array") && prop.Items != nil && prop.Items.Ref == "" && prop.Items.Type.Contains("array"):
+			// protobuf has no notion of repeated repeated fields, so the
+			// inner arrays are wrapped in a message with a repeated field
+			child, err := c.compileSchema(name+"List", &openapi.Schema{
+				Type:       openapi.SchemaType{"object"},
+				Properties: map[string]*openapi.Schema{"values": prop.Items},
+			})
+			if err != nil {
+				return "", nil, index, false, errors.Wrapf(err, `failed to compile nested array property %s`, name)
+			}
+			typ = child
 		case prop.Type.Contains("array"):
 			var copy openapi.Schema
 			copy = *(prop.Items)
 			copy.Description = ""
-			child, err := c.compileSchema(typName, &copy)
+			copy.ProtoComment = ""
+			if c.inflector != nil {
+				typName = c.inflector(name) + "Message"
+			}
+			child, err := c.compileItems(typName, &copy)
 			if err != nil {
 				return "", nil, index, false, errors.Wrapf(err, `failed to compile array property %s`, name)
 			}
@@ -861,7 +2692,7 @@ func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string,
 			if len(prop.Enum) > 0 {
 				p := c.parent()
 				enumName := p.Name() + "_" + name
-				typ, err = c.compileEnum(enumName, prop.Enum)
+				typ, err = c.compileEnum(enumName, prop)
 				if err != nil {
 					return "", nil, index, false, errors.Wrapf(err, `failed to compile enum for property %s`, name)
 				}
@@ -875,9 +2706,11 @@ func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string,
 				}
 			}
 
-			// optionally wrap primitives with wrapper messages
+			// optionally wrap primitives with wrapper messages.
+			// nullable primitives are always wrapped, so that the
+			// absence of a value can be distinguished
 			typ = c.applyBuiltinFormat(typ, prop.Format)
-			if c.wrapPrimitives {
+			if c.wrapPrimitives || prop.Nullable {
 				typ = c.getBoxedType(typ)
 			}
 		}
@@ -888,10 +2721,17 @@ func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string,
 		typ = p.ParameterType()
 		index = p.ParameterNumber()
 		repeated = p.Repeated()
+		// a tag given where the parameter is referenced wins
+		if v := prop.ProtoTag; v != 0 {
+			index = int(v)
+		}
 	} else {
 		if v := prop.ProtoName; v != "" {
 			name = v
 		}
+		if v := prop.ProtoFieldName; v != "" {
+			name = v
+		}
 		if v := prop.ProtoTag; v != 0 {
 			index = int(v)
 		}
@@ -900,22 +2740,95 @@ func (c *compileCtx) compileProperty(name string, prop *openapi.Schema) (string,
 		}
 	}
 
-	switch typ := typ.(type) {
-	case *protobuf.Message, *protobuf.Enum:
-		c.addType(typ)
+	// referenced types are declared wherever they were defined, which
+	// may be nested in another message
+	if !isReference(prop) {
+		switch typ := typ.(type) {
+		case *protobuf.Message, *protobuf.Enum:
+			c.addType(typ)
+		}
 	}
 	return name, typ, index, repeated, nil
 }
 
+// isReference returns true if the schema, or the items of an array
+// schema, refer to another schema
+func isReference(s *openapi.Schema) bool {
+	return s.Ref != "" || (s.Items != nil && s.Items.Ref != "")
+}
+
 func (c *compileCtx) addImportForType(name string) {
-	lib, ok := knownImports[name]
+	lib, ok := c.importForType(name)
 	if !ok {
 		return
 	}
 
+	if _, ok := c.imports[lib]; !ok {
+		c.typeImports[lib] = struct{}{}
+	}
 	c.addImport(lib)
 }
 
+// returns the file that declares the type `name`, if it is not
+// declared in the package itself
+func (c *compileCtx) importForType(name string) (string, bool) {
+	if lib, ok := knownImports[name]; ok {
+		return lib, true
+	}
+
+	for lib, types := range c.externalDefinitions {
+		for _, t := range types {
+			if t.Name() == name {
+				return lib, true
+			}
+		}
+	}
+	return "", false
+}
+
+// removes the imports that were added for the types that they declare,
+// once nothing that is left in the package after pruning refers to them
+func (c *compileCtx) pruneImports() error {
+	used := map[string]struct{}{}
+	var use func(protobuf.Type)
+	use = func(t protobuf.Type) {
+		if m, ok := t.(*protobuf.Map); ok {
+			use(m.Key())
+			use(m.Value())
+			return
+		}
+		if lib, ok := c.importForType(t.Name()); ok {
+			used[lib] = struct{}{}
+		}
+	}
+
+	err := c.pkg.Walk(func(n protobuf.Node) error {
+		switch n := n.(type) {
+		case *protobuf.Field:
+			use(n.Type())
+		case *protobuf.RPC:
+			use(n.Parameter())
+			use(n.Response())
+		case *protobuf.Extension:
+			use(n)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for lib := range c.typeImports {
+		if _, ok := used[lib]; ok {
+			continue
+		}
+		c.pkg.RemoveImport(lib)
+		delete(c.imports, lib)
+		delete(c.typeImports, lib)
+	}
+	return nil
+}
+
 func (c *compileCtx) addImport(lib string) {
 	if _, ok := c.imports[lib]; ok {
 		return
@@ -925,6 +2838,65 @@ func (c *compileCtx) addImport(lib string) {
 	c.imports[lib] = struct{}{}
 }
 
+// breadcrumbs describe the part of the spec that is being compiled,
+// e.g. #/definitions/Pet -> property owner. they are popped on the way
+// out, unless an error occurred, so that locateError can tell where
+// the error comes from
+func (c *compileCtx) pushBreadcrumb(s string) {
+	c.breadcrumbs = append(c.breadcrumbs, s)
+}
+
+func (c *compileCtx) popBreadcrumb() {
+	if l := len(c.breadcrumbs); l > 0 {
+		c.breadcrumbs = c.breadcrumbs[:l-1]
+	}
+}
+
+// truncateBreadcrumbs drops the breadcrumbs left by a failed compilation
+// whose error is not returned, so that they don't end up in the location
+// of later warnings and errors
+func (c *compileCtx) truncateBreadcrumbs(depth int) {
+	if depth < len(c.breadcrumbs) {
+		c.breadcrumbs = c.breadcrumbs[:depth]
+	}
+}
+
+// String returns the warning, preceded by its location if it has one
+func (w Warning) String() string {
+	if w.Location == "" {
+		return w.Message
+	}
+	return w.Location + ": " + w.Message
+}
+
+// warnf reports a lossy conversion of the construct being compiled to
+// the handler given by WithWarningHandler, once
+func (c *compileCtx) warnf(format string, args ...interface{}) {
+	if c.warningHandler == nil {
+		return
+	}
+
+	w := Warning{
+		Location: strings.Join(c.breadcrumbs, " -> "),
+		Message:  fmt.Sprintf(format, args...),
+	}
+	// definitions may be compiled more than once, e.g. for requests
+	if _, ok := c.warned[w]; ok {
+		return
+	}
+	c.warned[w] = struct{}{}
+	c.warningHandler(w)
+}
+
+// locateError adds the breadcrumbs left by the compilation that failed
+// with err to it
+func (c *compileCtx) locateError(err error) error {
+	if len(c.breadcrumbs) == 0 {
+		return err
+	}
+	return errors.Wrapf(err, `failed to compile %s`, strings.Join(c.breadcrumbs, " -> "))
+}
+
 func (c *compileCtx) pushParent(v protobuf.Container) {
 	c.parents = append(c.parents, v)
 }
@@ -956,6 +2928,10 @@ func (c *compileCtx) grandParent() protobuf.Container {
 
 // adds new type. dedupes, in case of multiple addition
 func (c *compileCtx) addType(t protobuf.Type) {
+	if _, ok := t.(*protobuf.Enum); ok && c.topLevelEnums {
+		c.addTypeToParent(t, c.pkg)
+		return
+	}
 	c.addTypeToParent(t, c.parent())
 }
 
@@ -1024,6 +3000,37 @@ func (c *compileCtx) addRPC(r *protobuf.RPC) {
 	c.service.AddRPC(r)
 }
 
+// endpointName returns the name of the rpc generated for the endpoint,
+// using the operationId transformer if there is one
+func (c *compileCtx) endpointName(e *openapi.Endpoint) (string, error) {
+	if e.OperationID == "" || c.operationIDTransformer == nil {
+		return normalizeEndpointName(e), nil
+	}
+
+	name := c.operationIDTransformer(e.OperationID)
+	if !isIdentifier(name) {
+		return "", errors.Errorf(`invalid rpc name %q for operationId %s`, name, e.OperationID)
+	}
+	return name, nil
+}
+
+// different paths may normalize to the same endpoint name (e.g. /foo/{id}
+// and /foo/id), in which case the rpc and its messages would silently
+// replace each other. the later endpoint gets a numeric suffix instead
+func (c *compileCtx) uniqueEndpointName(name string) string {
+	if _, ok := c.rpcs[name]; !ok {
+		return name
+	}
+
+	for i := 2; ; i++ {
+		candidate := name + strconv.Itoa(i)
+		if _, ok := c.rpcs[candidate]; !ok {
+			c.warnf(`conflicts with an existing rpc named %s, renamed to %s`, name, candidate)
+			return candidate
+		}
+	}
+}
+
 func (c *compileCtx) compilePaths(paths map[string]*openapi.Path) error {
 	var sortedPaths []string
 	for path := range paths {
@@ -1047,7 +3054,7 @@ func (c *compileCtx) createListWrapper(name string, rawName string, baseFieldNam
 	m := protobuf.NewMessage(mapValueName)
 	f := protobuf.NewField(protobuf.NewMessage(baseFieldName), rawName, 1)
 	f.SetRepeated(true)
-	if v := s.Description; len(v) > 0 {
+	if v := schemaComment(s); len(v) > 0 {
 		f.SetComment(v)
 	}
 	m.AddField(f)
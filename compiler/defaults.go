@@ -0,0 +1,47 @@
+package compiler
+
+import (
+	"github.com/NYTimes/openapi2proto/openapi"
+	"github.com/NYTimes/openapi2proto/protobuf"
+	"github.com/pkg/errors"
+)
+
+// ExtractDefaults compiles the OpenAPI spec, and returns the default
+// values of the fields of the generated messages, which proto3 has no
+// way to declare. The values are taken from the `default` keys of the
+// spec, and are keyed by the name of the message and the name of the
+// field, e.g. "Pet.status", or "Pet.Owner.name" for nested messages.
+// Only fields of scalar types, or arrays of them, are included
+func ExtractDefaults(spec *openapi.Spec, options ...Option) (map[string]interface{}, error) {
+	p, err := Compile(spec, options...)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to compile spec`)
+	}
+
+	return PackageDefaults(p), nil
+}
+
+// PackageDefaults returns the default values of the fields of the messages
+// of a package compiled from a spec, keyed like those of ExtractDefaults
+func PackageDefaults(p *protobuf.Package) map[string]interface{} {
+	defaults := map[string]interface{}{}
+	extractDefaults(defaults, "", p.Children())
+	return defaults
+}
+
+func extractDefaults(defaults map[string]interface{}, prefix string, types []protobuf.Type) {
+	for _, t := range types {
+		m, ok := t.(*protobuf.Message)
+		if !ok {
+			continue
+		}
+
+		name := prefix + m.Name()
+		for _, f := range m.Fields() {
+			if v := f.Default(); v != nil {
+				defaults[name+"."+f.Name()] = v
+			}
+		}
+		extractDefaults(defaults, name+".", m.Children())
+	}
+}
@@ -14,26 +14,98 @@ const (
 	phaseCompilePaths
 )
 
+// prefix of the keys under which the request variants of definitions
+// are registered. see WithSplitReadWriteOnly
+const requestRefPrefix = "request:"
+
+// the largest field number that protobuf allows. see WithFieldNumberBase
+const maxFieldNumber = 536870911
+
 // Option is used to pass options to several methods
 type Option = option.Option
 
+// TypeMapper is a function that may provide the protobuf type for a
+// schema, instead of the compiler. name is the name the compiler would
+// use for the generated type. If the returned bool is false, the schema
+// is compiled as usual. See WithTypeMapper
+type TypeMapper func(name string, s *openapi.Schema) (protobuf.Type, bool, error)
+
+// Warning describes a construct of the spec that could not be compiled
+// faithfully, e.g. a constraint that protobuf can't express. See
+// WithWarningHandler
+type Warning struct {
+	// where the construct was found, e.g. "#/definitions/Pet -> property name"
+	Location string
+	Message  string
+}
+
 type compileCtx struct {
-	annotate            bool
-	skipRpcs            bool
-	skipDeprecatedRpcs  bool
-	prefixEnums         bool
-	wrapPrimitives      bool
-	definitions         map[string]protobuf.Type
-	externalDefinitions map[string]map[string]protobuf.Type
-	imports             map[string]struct{}
-	parents             []protobuf.Container
-	phase               int
-	pkg                 *protobuf.Package
-	rpcs                map[string]*protobuf.RPC
-	spec                *openapi.Spec
-	service             *protobuf.Service
-	types               map[protobuf.Container]map[protobuf.Type]struct{}
-	unfulfilledRefs     map[string]struct{}
-	messageNames        map[string]bool
-	wrapperMessages     map[string]bool
+	annotate                    bool
+	skipRpcs                    bool
+	messagesOnly                bool
+	skipDeprecatedRpcs          bool
+	prefixEnums                 bool
+	wrapPrimitives              bool
+	ignoreParamLocations        map[string]struct{}
+	extraImports                []string
+	tagsAsComment               bool
+	tagsAsOption                string
+	securityAsComment           bool
+	emptyObjectAsStruct         bool
+	topLevelEnums               bool
+	useSchemaTitle              bool
+	examplesAsComments          bool
+	emptyType                   string
+	fieldNumberBase             int
+	defaultIntegerType          string
+	securityAsOption            string
+	concreteEmptyMessages       bool
+	splitReadWriteOnly          bool
+	splitDefinitions            map[string]struct{}
+	inflector                   func(string) string
+	operationIDTransformer      func(string) string
+	warningHandler              func(Warning)
+	warned                      map[Warning]struct{}
+	typeMapper                  TypeMapper
+	errorResponses              bool
+	includeTags                 map[string]struct{}
+	additionalPropertiesComment bool
+	jsonNameOption              bool
+	pruneUnusedMessages         bool
+	pruneRoots                  []string
+	wellKnownTypes              bool
+	httpComment                 bool
+	requestSuffix               string
+	responseSuffix              string
+	excludeTags                 map[string]struct{}
+	excludeDefinitions          map[string]struct{}
+	inRequest                   bool
+	definitions                 map[string]protobuf.Type
+	externalDefinitions         map[string]map[string]protobuf.Type
+	imports                     map[string]struct{}
+	typeImports                 map[string]struct{}
+	parents                     []protobuf.Container
+	breadcrumbs                 []string
+	enumNames                   nameCache
+	fieldNames                  nameCache
+	snakeCaseNames              nameCache
+	refPaths                    []string
+	phase                       int
+	pkg                         *protobuf.Package
+	rpcs                        map[string]*protobuf.RPC
+	spec                        *openapi.Spec
+	service                     *protobuf.Service
+	types                       map[protobuf.Container]map[protobuf.Type]struct{}
+	unfulfilledRefs             map[string]struct{}
+	messageNames                map[string]bool
+	wrapperMessages             map[string]bool
+	mapWrappers                 map[string]*protobuf.Message
+	hoistedEnums                map[string]hoistedEnum
+}
+
+// hoistedEnum records where an enum that was moved to the package
+// with WithTopLevelEnums was declared, and its schema
+type hoistedEnum struct {
+	parent string
+	schema *openapi.Schema
 }
@@ -3,11 +3,44 @@ package compiler
 import "github.com/NYTimes/openapi2proto/internal/option"
 
 const (
-	optkeyAnnotation         = "annotation"
-	optkeySkipRpcs           = "skip-rpcs"
-	optKeySkipDeprecatedRpcs = "skip-deprecated-rpcs"
-	optkeyPrefixEnums        = "namespace-enums"
-	optkeyWrapPrimitives     = "wrap-primitives"
+	optkeyAnnotation                  = "annotation"
+	optkeySkipRpcs                    = "skip-rpcs"
+	optKeySkipDeprecatedRpcs          = "skip-deprecated-rpcs"
+	optkeyPrefixEnums                 = "namespace-enums"
+	optkeyWrapPrimitives              = "wrap-primitives"
+	optkeyIgnoreParamLocations        = "ignore-param-locations"
+	optkeyExtraImports                = "extra-imports"
+	optkeyTagsAsComment               = "tags-as-comment"
+	optkeyTagsAsOption                = "tags-as-option"
+	optkeySecurityAsComment           = "security-as-comment"
+	optkeySecurityAsOption            = "security-as-option"
+	optkeyEmptyObjectAsStruct         = "empty-object-as-struct"
+	optkeyTopLevelEnums               = "top-level-enums"
+	optkeyUseSchemaTitle              = "use-schema-title"
+	optkeyFieldNumberBase             = "field-number-base"
+	optkeyExamplesAsComments          = "examples-as-comments"
+	optkeyEmptyType                   = "empty-type"
+	optkeyConcreteEmptyMessages       = "concrete-empty-messages"
+	optkeySplitReadWriteOnly          = "split-read-write-only"
+	optkeyInflector                   = "inflector"
+	optkeyTypeMapper                  = "type-mapper"
+	optkeyErrorResponses              = "error-responses"
+	optkeyServiceName                 = "service-name"
+	optkeyIncludeTags                 = "include-tags"
+	optkeyExcludeTags                 = "exclude-tags"
+	optkeyExcludeDefinitions          = "exclude-definitions"
+	optkeyAdditionalPropertiesComment = "additional-properties-comment"
+	optkeyJSONNameOption              = "json-name-option"
+	optkeyPruneUnusedMessages         = "prune-unused-messages"
+	optkeyPruneRoots                  = "prune-roots"
+	optkeyWellKnownTypes              = "well-known-types"
+	optkeyRequestSuffix               = "request-suffix"
+	optkeyHTTPComment                 = "http-comment"
+	optkeyResponseSuffix              = "response-suffix"
+	optkeyOperationIDTransformer      = "operation-id-transformer"
+	optkeyMessagesOnly                = "messages-only"
+	optkeyDefaultIntegerType          = "default-integer-type"
+	optkeyWarningHandler              = "warning-handler"
 )
 
 // WithAnnotation creates a new Option to specify if we should add
@@ -39,3 +72,262 @@ func WithPrefixEnums(b bool) Option {
 func WithWrapPrimitives(b bool) Option {
 	return option.New(optkeyWrapPrimitives, b)
 }
+
+// WithIgnoreParamLocations creates a new Option to specify parameter
+// locations (e.g. "header", "cookie") that should be excluded from
+// the generated request messages
+func WithIgnoreParamLocations(l []string) Option {
+	return option.New(optkeyIgnoreParamLocations, l)
+}
+
+// WithExtraImports creates a new Option to specify additional files
+// that should be imported by the generated Protocol Buffers declaration,
+// such as those defining custom options. These are merged with the
+// imports that are automatically detected
+func WithExtraImports(l []string) Option {
+	return option.New(optkeyExtraImports, l)
+}
+
+// WithTagsAsComment creates a new Option to specify if the tags of
+// each operation should be listed in the comment of the generated rpc
+func WithTagsAsComment(b bool) Option {
+	return option.New(optkeyTagsAsComment, b)
+}
+
+// WithTagsAsOption creates a new Option to specify the name of a
+// custom rpc option that should be used to carry the tags of each
+// operation. The tags are given to the option as a comma separated string
+func WithTagsAsOption(name string) Option {
+	return option.New(optkeyTagsAsOption, name)
+}
+
+// WithSecurityAsComment creates a new Option to specify if the security
+// requirements of each operation should be listed in the comment of
+// the generated rpc
+func WithSecurityAsComment(b bool) Option {
+	return option.New(optkeySecurityAsComment, b)
+}
+
+// WithSecurityAsOption creates a new Option to specify the name of a
+// custom rpc option that should be used to carry the security requirements
+// of each operation, e.g. "oauth2:read,write | api_key". Alternative
+// requirements are separated by " | ", and schemes that are required
+// together by " & "
+func WithSecurityAsOption(name string) Option {
+	return option.New(optkeySecurityAsOption, name)
+}
+
+// WithConcreteEmptyMessages creates a new Option to specify if rpcs
+// without parameters or response bodies should use empty FooRequest
+// and FooResponse messages instead of google.protobuf.Empty, so that
+// fields can be added later without changing the rpc signature
+func WithConcreteEmptyMessages(b bool) Option {
+	return option.New(optkeyConcreteEmptyMessages, b)
+}
+
+// WithSplitReadWriteOnly creates a new Option to specify if readOnly
+// properties should be left out of requests, and writeOnly properties
+// out of responses. Definitions with such properties are compiled into
+// two messages: Foo for responses, and FooRequest for requests
+func WithSplitReadWriteOnly(b bool) Option {
+	return option.New(optkeySplitReadWriteOnly, b)
+}
+
+// WithInflector creates a new Option to specify the function used to
+// derive the name of the items of an array from the name of the array
+// property, e.g. to name the messages for the items of `addresses` as
+// Address instead of Addresses. Singularize is provided for this purpose.
+// By default, the items are named after the property as is.
+func WithInflector(fn func(string) string) Option {
+	return option.New(optkeyInflector, fn)
+}
+
+// WithTypeMapper creates a new Option to specify a function that is
+// consulted before compiling each schema, e.g. to map all schemas with
+// a given vendor extension to a shared message. Messages and enums
+// that it returns are declared in the package, and imports are added
+// for well known types
+func WithTypeMapper(fn TypeMapper) Option {
+	return option.New(optkeyTypeMapper, fn)
+}
+
+// WithErrorResponses creates a new Option to specify if messages should
+// be generated for the inline schemas of responses other than 2xx, which
+// are otherwise ignored. The messages are named after the description
+// of the response, e.g. FooNotFoundResponse, or after the status code,
+// e.g. FooResponse404, if the description is empty or too long
+func WithErrorResponses(b bool) Option {
+	return option.New(optkeyErrorResponses, b)
+}
+
+// WithServiceName creates a new Option to specify the name of the
+// generated service. By default, the service is named after the title
+// of the spec, with a "Service" suffix
+func WithServiceName(s string) Option {
+	return option.New(optkeyServiceName, s)
+}
+
+// WithIncludeTags creates a new Option to specify the tags of the
+// endpoints that rpcs should be generated for. Endpoints that have none
+// of these tags are skipped, along with their request and response
+// messages. By default, all endpoints are included
+func WithIncludeTags(tags []string) Option {
+	return option.New(optkeyIncludeTags, tags)
+}
+
+// WithExcludeTags creates a new Option to specify tags of endpoints
+// that should be skipped, along with their request and response
+// messages. This takes precedence over WithIncludeTags
+func WithExcludeTags(tags []string) Option {
+	return option.New(optkeyExcludeTags, tags)
+}
+
+// WithExcludeDefinitions creates a new Option to specify the names of
+// definitions (the X in #/definitions/X) that should not be compiled,
+// e.g. internal models. Compilation fails if anything that is compiled
+// refers to one of them
+func WithExcludeDefinitions(names []string) Option {
+	return option.New(optkeyExcludeDefinitions, names)
+}
+
+// WithAdditionalPropertiesComment creates a new Option to specify if
+// messages for objects with `additionalProperties: false` should say so
+// in their comment, as protobuf has no way to express it otherwise
+func WithAdditionalPropertiesComment(b bool) Option {
+	return option.New(optkeyAdditionalPropertiesComment, b)
+}
+
+// WithJSONNameOption creates a new Option to specify if fields whose
+// names differ from the original property names (e.g. `user-id` becomes
+// user_id) should carry a json_name option with the original name, so
+// that the JSON mapping of the message matches the OpenAPI spec
+func WithJSONNameOption(b bool) Option {
+	return option.New(optkeyJSONNameOption, b)
+}
+
+// WithPruneUnusedMessages creates a new Option to specify if messages
+// and enums that are not used, directly or indirectly, by any of the
+// generated rpcs or extensions should be left out of the package
+func WithPruneUnusedMessages(b bool) Option {
+	return option.New(optkeyPruneUnusedMessages, b)
+}
+
+// WithPruneRoots creates a new Option to specify the names of messages
+// and enums that are kept, along with anything they use, when unused
+// messages are pruned, even if no rpc or extension uses them
+func WithPruneRoots(names []string) Option {
+	return option.New(optkeyPruneRoots, names)
+}
+
+// WithWellKnownTypes creates a new Option to specify if strings with
+// formats that have a matching well known type should use it, i.e.
+// google.protobuf.Timestamp for `date-time`, and google.protobuf.Duration
+// for `duration`
+func WithWellKnownTypes(b bool) Option {
+	return option.New(optkeyWellKnownTypes, b)
+}
+
+// WithRequestSuffix creates a new Option to specify the suffix that is
+// appended to the name of an rpc to name its request message. If not
+// specified, "Request" is used, e.g. GetPetRequest
+func WithRequestSuffix(s string) Option {
+	return option.New(optkeyRequestSuffix, s)
+}
+
+// WithResponseSuffix creates a new Option to specify the suffix that is
+// appended to the name of an rpc to name its response messages. If not
+// specified, "Response" is used, e.g. GetPetResponse
+func WithResponseSuffix(s string) Option {
+	return option.New(optkeyResponseSuffix, s)
+}
+
+// WithHTTPCommentOnRPC creates a new Option to specify if the comment
+// of each rpc should start with the HTTP method and path of the
+// endpoint, e.g. `GET /v1/pets/{id}`, which is useful to see how rpcs
+// map to endpoints without enabling annotations
+func WithHTTPCommentOnRPC(b bool) Option {
+	return option.New(optkeyHTTPComment, b)
+}
+
+// WithEmptyObjectAsStruct creates a new Option to specify if objects
+// without properties and additionalProperties, which usually stand for
+// arbitrary JSON, should be compiled to google.protobuf.Struct instead
+// of empty messages
+func WithEmptyObjectAsStruct(b bool) Option {
+	return option.New(optkeyEmptyObjectAsStruct, b)
+}
+
+// WithTopLevelEnums creates a new Option to specify if enums should
+// always be declared in the package, instead of in the message that
+// uses them. The names of the enclosing messages are prepended to the
+// names of such enums, e.g. PetStatus for a Status enum used by Pet
+func WithTopLevelEnums(b bool) Option {
+	return option.New(optkeyTopLevelEnums, b)
+}
+
+// WithUseSchemaTitle creates a new Option to specify if the title of a
+// schema, when present, should be used as the name of the message or
+// enum generated for it. References to definitions are resolved by
+// their keys either way. x-proto-message-name takes precedence
+func WithUseSchemaTitle(b bool) Option {
+	return option.New(optkeyUseSchemaTitle, b)
+}
+
+// WithFieldNumberBase creates a new Option to specify the number that
+// automatically assigned field numbers start from, e.g. 10 to leave
+// 1 through 9 free for fields added later. Numbers given by x-proto-tag
+// are used as is, and are never assigned to other fields. Defaults to 1
+func WithFieldNumberBase(n int) Option {
+	return option.New(optkeyFieldNumberBase, n)
+}
+
+// WithExamplesAsComments creates a new Option to specify if the example
+// of the successful response of each endpoint, if any, should be added
+// to the comment of the rpc. Long examples are truncated
+func WithExamplesAsComments(b bool) Option {
+	return option.New(optkeyExamplesAsComments, b)
+}
+
+// WithEmptyType creates a new Option to specify the message used for
+// rpcs without parameters or response bodies, e.g. common.Empty,
+// instead of google.protobuf.Empty. The import is only added for well
+// known types, so other types may need WithExtraImports. This has no
+// effect on rpcs that use concrete empty messages
+func WithEmptyType(s string) Option {
+	return option.New(optkeyEmptyType, s)
+}
+
+// WithOperationIDTransformer creates a new Option to specify the
+// function used to derive the name of an rpc from the operationId of
+// its endpoint, e.g. to keep operationIds that are already valid names
+// as is. By default, operationIds are camel cased, so that getUserByID
+// becomes GetUserById. It is an error for the function to return a
+// name that is not a valid identifier
+func WithOperationIDTransformer(fn func(string) string) Option {
+	return option.New(optkeyOperationIDTransformer, fn)
+}
+
+// WithMessagesOnly creates a new Option to specify if only the messages
+// and enums for the definitions, parameters and responses of the spec
+// should be generated. Unlike WithSkipRpcs, the package has no service
+// at all, and the google.api.http annotations are never imported
+func WithMessagesOnly(b bool) Option {
+	return option.New(optkeyMessagesOnly, b)
+}
+
+// WithDefaultIntegerType creates a new Option to specify the type of
+// integers without a format, either "int32" (the default) or "int64",
+// e.g. for APIs whose JSON numbers may not fit in 32 bits. Integers
+// with a format are not affected
+func WithDefaultIntegerType(s string) Option {
+	return option.New(optkeyDefaultIntegerType, s)
+}
+
+// WithWarningHandler creates a new Option to specify the function that
+// is called with the lossy conversions made during compilation, e.g.
+// schemas with `not` or multiple types compiled to google.protobuf.Any,
+// ignored validation keywords, or rpcs renamed to avoid conflicts.
+// Warnings are discarded if no handler is given
+func WithWarningHandler(fn func(Warning)) Option {
+	return option.New(optkeyWarningHandler, fn)
+}
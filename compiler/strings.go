@@ -1,7 +1,6 @@
 package compiler
 
 import (
-	"bytes"
 	"net/url"
 	"path"
 	"strings"
@@ -18,7 +17,8 @@ func isAlphaNum(r rune) bool {
 }
 
 func allCaps(s string) string {
-	var buf bytes.Buffer
+	var buf strings.Builder
+	buf.Grow(len(s))
 	for _, r := range s {
 		// replace all non-alpha-numeric characters with an underscore
 		if !isAlphaNum(r) {
@@ -33,7 +33,8 @@ func allCaps(s string) string {
 
 func normalizeFieldName(s string) string {
 	var wasUnderscore bool
-	var buf bytes.Buffer
+	var buf strings.Builder
+	buf.Grow(len(s))
 	for _, r := range s {
 		if !isAlphaNum(r) {
 			if !wasUnderscore {
@@ -48,110 +49,146 @@ func normalizeFieldName(s string) string {
 	return buf.String()
 }
 
-func dedupe(s string, r rune) string {
-	var buf bytes.Buffer
-	var wasTarget bool
-	for _, r1 := range s {
-		if r1 == r {
-			if !wasTarget {
-				buf.WriteRune(r1)
-				wasTarget = true
-			}
-			continue
-		}
+func isUpper(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
 
-		wasTarget = false
-		buf.WriteRune(r1)
-	}
-	return buf.String()
+func isLower(b byte) bool {
+	return b >= 'a' && b <= 'z'
 }
 
-func removeNonAlphaNum(s string) string {
-	var buf bytes.Buffer
-	for _, r := range s {
-		if !isAlphaNum(r) {
-			switch r {
-			case '_', '-', ' ':
-				r = '_'
-			default:
-				continue
-			}
-		}
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
 
-		buf.WriteRune(r)
+func toLower(b byte) byte {
+	if isUpper(b) {
+		return b + 'a' - 'A'
 	}
-	return buf.String()
+	return b
 }
 
 func snakeCase(s string) string {
+	// pass 1: keep the alpha-numeric characters, and turn each run of
+	// separators ('_', '-' and ' ') into a single underscore. anything
+	// else is removed
+	var clean strings.Builder
+	clean.Grow(len(s))
 	var wasUnderscore bool
-	// pass 1: remove all non-alpha-numeric characters EXCEPT for underscore
-	s = removeNonAlphaNum(s)
-	// pass 2: dedupe '_'
-	s = dedupe(s, '_')
-
-	var runes []rune
-	for _, r := range s {
-		if !isAlphaNum(r) {
-			if !wasUnderscore {
-				runes = append(runes, '_')
-				wasUnderscore = true
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case isAlphaNum(rune(b)):
+			if wasUnderscore && clean.Len() > 0 {
+				clean.WriteByte('_')
 			}
-			continue
+			wasUnderscore = false
+			clean.WriteByte(b)
+		case b == '_' || b == '-' || b == ' ':
+			wasUnderscore = true
 		}
-		wasUnderscore = false
-		runes = append(runes, r)
 	}
 
-	for len(runes) > 0 && runes[0] == '_' {
-		runes = runes[1:]
-	}
-	for len(runes) > 0 && runes[len(runes)-1] == '_' {
-		runes = runes[:len(runes)-1]
+	// pass 2: break the remaining runs of characters into words, and
+	// join them back with underscores
+	return joinWords(clean.String())
+}
+
+// commonInitialisms are the acronyms that runs of upper case letters
+// are split into, so that HTTPURL becomes http_url
+var commonInitialisms = []string{
+	"ACL", "API", "ASCII", "CPU", "CSS", "DNS", "EOF", "GUID", "HTML",
+	"HTTP", "HTTPS", "ID", "IP", "JSON", "JWT", "LHS", "QPS", "RAM", "RHS",
+	"RPC", "SLA", "SMTP", "SQL", "SSH", "TCP", "TLS", "TTL", "UDP", "UI",
+	"UID", "URI", "URL", "UTF8", "UUID", "VM", "XML", "XMPP", "XSRF", "XSS",
+}
+
+// joinWords splits s at underscores, where a lower case letter or a
+// digit is followed by an upper case letter (userName), and before the
+// last letter of a run of upper case letters that is followed by a
+// lower case letter (HTTPServer). Runs of upper case letters made of
+// common initialisms are split into them (HTTPURL). The words are
+// lower cased, and joined with underscores
+func joinWords(s string) string {
+	var buf strings.Builder
+	buf.Grow(len(s) + len(s)/2)
+	writeWord := func(word string) {
+		if word == "" {
+			return
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('_')
+		}
+		for i := 0; i < len(word); i++ {
+			buf.WriteByte(toLower(word[i]))
+		}
 	}
 
-	// pass 2: for each consecutive upper case characters, insert
-	// a break between the last upper case character and its
-	// predecessor
-	var wasUpper int
-	var buf bytes.Buffer
-	for i := 0; i < len(runes); i++ {
-		if !isAlphaNum(runes[i]) {
-			if !wasUnderscore {
-				buf.WriteRune('_')
-				wasUnderscore = true
-			}
+	var start int
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b == '_' {
+			writeInitialisms(s[start:i], writeWord)
+			start = i + 1
+			continue
+		}
+		if i == start || !isUpper(b) {
 			continue
 		}
 
-		// if it's upper cased, check if we have a succession of
-		// uppercase letters.
-		if unicode.IsUpper(runes[i]) {
-			if wasUpper == 0 && buf.Len() > 0 && !wasUnderscore {
-				buf.WriteRune('_')
-			}
-			wasUpper++
-		} else {
-			if wasUpper > 1 && buf.Len() != 1 {
-				if len(runes) > 1 && runes[i-2] != '_' && runes[i-1] != '_' {
-					buf.Truncate(buf.Len() - 1)                // remove last upper case letter
-					buf.WriteRune('_')                         // insert rune
-					buf.WriteRune(unicode.ToLower(runes[i-1])) // re-insert last letter
-				}
-			}
-			wasUpper = 0
+		prev := s[i-1]
+		if isLower(prev) || isDigit(prev) || (isUpper(prev) && i+1 < len(s) && isLower(s[i+1])) {
+			writeInitialisms(s[start:i], writeWord)
+			start = i
 		}
-		wasUnderscore = false
-		buf.WriteRune(unicode.ToLower(runes[i]))
 	}
-
+	writeInitialisms(s[start:], writeWord)
 	return buf.String()
 }
 
+// writeInitialisms writes a word made entirely of common initialisms
+// as separate words, preferring the longest initialisms. Other words
+// are written as is
+func writeInitialisms(word string, write func(string)) {
+	for i := 0; i < len(word); i++ {
+		if !isUpper(word[i]) && !isDigit(word[i]) {
+			write(word)
+			return
+		}
+	}
+
+	if firstInitialism(word) == 0 {
+		write(word)
+		return
+	}
+	for word != "" {
+		n := firstInitialism(word)
+		write(word[:n])
+		word = word[n:]
+	}
+}
+
+// firstInitialism returns the length of the longest initialism that s
+// starts with, such that the rest of s is made of initialisms as well,
+// or 0 if s is not made of initialisms
+func firstInitialism(s string) int {
+	var best int
+	for _, initialism := range commonInitialisms {
+		if len(initialism) <= best || !strings.HasPrefix(s, initialism) {
+			continue
+		}
+		if rest := s[len(initialism):]; rest == "" || firstInitialism(rest) > 0 {
+			best = len(initialism)
+		}
+	}
+	return best
+}
+
 func camelCase(s string) string {
 	var first = true
 	var wasUnderscore bool
-	var buf bytes.Buffer
+	var buf strings.Builder
+	buf.Grow(len(s))
 	for _, r := range s {
 		// replace all non-alpha-numeric characters with an underscore
 		if !isAlphaNum(r) {
@@ -177,7 +214,8 @@ func camelCase(s string) string {
 // takes strings like "foo bar baz" and turns it into "foobarbaz"
 // if title is true, then "FooBarBaz"
 func concatSpaces(s string, title bool) string {
-	var buf bytes.Buffer
+	var buf strings.Builder
+	buf.Grow(len(s))
 	var wasSpace bool
 	for i, r := range s {
 		if unicode.IsSpace(r) {
@@ -198,11 +236,17 @@ func packageName(s string) string {
 }
 
 func normalizeServiceName(s string) string {
-	return camelCase(concatSpaces(s, true) + "Service")
+	name := camelCase(concatSpaces(s, true))
+	// don't end up with names like PetStoreServiceService
+	if strings.HasSuffix(name, "Service") {
+		return name
+	}
+	return name + "Service"
 }
 
 func cleanCharacters(input string) string {
-	var buf bytes.Buffer
+	var buf strings.Builder
+	buf.Grow(len(input))
 	for _, r := range input {
 		// anything other than a-z, A-Z, 0-9 should be converted
 		// to an underscore
@@ -227,7 +271,7 @@ func normalizeEndpointName(e *openapi.Endpoint) string {
 		p = p[:i]
 	}
 
-	var buf bytes.Buffer
+	var buf strings.Builder
 	for _, r := range p {
 		switch r {
 		case '_', '-', '.', '/':
@@ -246,14 +290,8 @@ func normalizeEndpointName(e *openapi.Endpoint) string {
 	return camelCase(e.Verb) + name
 }
 
-func looksLikeInteger(s string) bool {
-	for _, r := range s {
-		// charcter should be between "0" to "9" or else.
-		if 0x30 > r || 0x39 < r {
-			return false
-		}
-	}
-	return true
+func startsWithDigit(s string) bool {
+	return len(s) > 0 && s[0] >= '0' && s[0] <= '9'
 }
 
 func normalizeEnumName(s string) string {
@@ -271,3 +309,160 @@ func normalizeEnumName(s string) string {
 func operationIDToName(s string) string {
 	return camelCase(snakeCase(s))
 }
+
+// words that are the same in singular and plural form
+var uncountableWords = []string{
+	"data", "equipment", "information", "metadata", "news", "series", "species",
+}
+
+// plural forms that can't be derived by the suffix rules
+var irregularPlurals = map[string]string{
+	"children": "child",
+	"feet":     "foot",
+	"geese":    "goose",
+	"halves":   "half",
+	"knives":   "knife",
+	"leaves":   "leaf",
+	"lives":    "life",
+	"men":      "man",
+	"mice":     "mouse",
+	"people":   "person",
+	"shelves":  "shelf",
+	"teeth":    "tooth",
+	"wives":    "wife",
+	"wolves":   "wolf",
+	"women":    "woman",
+}
+
+// suffix rules, applied in order. the first matching rule wins
+var singularSuffixes = []struct {
+	plural   string
+	singular string
+}{
+	{"sses", "ss"},
+	{"ies", "y"},
+	{"xes", "x"},
+	{"ches", "ch"},
+	{"shes", "sh"},
+	{"zzes", "zz"},
+	// plurals of words ending in "us" drop "es", but only for the
+	// endings of such words, as "houses" or "responses" just drop "s"
+	{"tuses", "tus"},
+	{"buses", "bus"},
+	{"nuses", "nus"},
+	{"puses", "pus"},
+	{"ruses", "rus"},
+	{"ss", "ss"},
+	{"us", "us"},
+	{"is", "is"},
+	{"s", ""},
+}
+
+// Singularize returns the singular form of the English word at the end of
+// s, e.g. "addresses" becomes "address", and "user_categories" becomes
+// "user_category". It can be used with WithInflector.
+func Singularize(s string) string {
+	// the last word starts after the last separator, or at the last
+	// upper case letter for camel cased names
+	start := strings.LastIndexAny(s, "_- ") + 1
+	for i := len(s) - 1; i > start; i-- {
+		if s[i] >= 'A' && s[i] <= 'Z' {
+			start = i
+			break
+		}
+	}
+	prefix, word := s[:start], s[start:]
+	lower := strings.ToLower(word)
+
+	for _, w := range uncountableWords {
+		if lower == w {
+			return s
+		}
+	}
+
+	if singular, ok := irregularPlurals[lower]; ok {
+		return prefix + matchCase(word, singular)
+	}
+
+	for _, rule := range singularSuffixes {
+		// don't turn words like "ties" into "t". the part of the suffix
+		// that the singular keeps, like "bus" in "buses", counts as
+		// part of the word
+		kept := len(word) - len(rule.plural) + commonPrefixLen(rule.plural, rule.singular)
+		if strings.HasSuffix(lower, rule.plural) && kept > 1 {
+			return prefix + word[:len(word)-len(rule.plural)] + rule.singular
+		}
+	}
+	return s
+}
+
+// returns the length of the common prefix of a and b
+func commonPrefixLen(a, b string) int {
+	var n int
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// returns s with the first letter in upper case if the first letter
+// of word is
+func matchCase(word, s string) string {
+	if len(word) > 0 && len(s) > 0 && unicode.IsUpper(rune(word[0])) {
+		return strings.ToUpper(s[:1]) + s[1:]
+	}
+	return s
+}
+
+// the maximum number of words in a description that is turned into
+// part of a message name. longer descriptions make for unwieldy names
+const maxDescriptionNameWords = 4
+
+// descriptionToName turns a short description such as "not found" or
+// "Unexpected error." into an identifier like NotFound or UnexpectedError.
+// An empty string is returned if the description is empty, or too long
+// to be used as a name
+func descriptionToName(s string) string {
+	if i := strings.IndexByte(s, '\n'); i > -1 {
+		s = s[:i]
+	}
+
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !isAlphaNum(r)
+	})
+	if len(words) == 0 || len(words) > maxDescriptionNameWords {
+		return ""
+	}
+
+	// identifiers can't start with a digit
+	if r := words[0][0]; r >= '0' && r <= '9' {
+		return ""
+	}
+
+	var buf strings.Builder
+	for _, w := range words {
+		buf.WriteString(strings.ToUpper(w[:1]))
+		buf.WriteString(strings.ToLower(w[1:]))
+	}
+	return buf.String()
+}
+
+// nameCache memoizes a name conversion for the duration of a
+// compilation, as large specs convert the same names over and over
+type nameCache struct {
+	fn    func(string) string
+	names map[string]string
+}
+
+func newNameCache(fn func(string) string) nameCache {
+	return nameCache{fn: fn, names: map[string]string{}}
+}
+
+func (nc nameCache) convert(s string) string {
+	if v, ok := nc.names[s]; ok {
+		return v
+	}
+	v := nc.fn(s)
+	nc.names[s] = v
+	return v
+}
@@ -20,6 +20,30 @@ func TestEndpointNames(t *testing.T) {
 			},
 			Expected: "GetQueueIdEnqueuePlayer",
 		},
+		{
+			Endpoint: openapi.Endpoint{
+				Path:        "/users/{id}",
+				Verb:        "get",
+				OperationID: "getUserByID",
+			},
+			Expected: "GetUserById",
+		},
+		{
+			Endpoint: openapi.Endpoint{
+				Path:        "/pets",
+				Verb:        "get",
+				OperationID: "list_pets",
+			},
+			Expected: "ListPets",
+		},
+		{
+			Endpoint: openapi.Endpoint{
+				Path:        "/v2/search",
+				Verb:        "get",
+				OperationID: "v2.search",
+			},
+			Expected: "V2search",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.Endpoint.Path, func(t *testing.T) {
@@ -59,3 +83,131 @@ func TestEnumNames(t *testing.T) {
 		})
 	}
 }
+
+func TestSingularize(t *testing.T) {
+	var tests = map[string]string{
+		"addresses":         "address",
+		"categories":        "category",
+		"children":          "child",
+		"data":              "data",
+		"pets":              "pet",
+		"status":            "status",
+		"statuses":          "status",
+		"buses":             "bus",
+		"bonuses":           "bonus",
+		"campuses":          "campus",
+		"viruses":           "virus",
+		"houses":            "house",
+		"responses":         "response",
+		"bus":               "bus",
+		"boxes":             "box",
+		"user_categories":   "user_category",
+		"shippingAddresses": "shippingAddress",
+		"OrderChildren":     "OrderChild",
+		"userData":          "userData",
+		"specimen":          "specimen",
+	}
+
+	for source, expected := range tests {
+		t.Run(source, func(t *testing.T) {
+			if v := Singularize(source); v != expected {
+				t.Errorf("Singularize failed: expected %s, got %s", expected, v)
+			}
+		})
+	}
+}
+
+func TestDescriptionToName(t *testing.T) {
+	var tests = map[string]string{
+		"Not Found":                              "NotFound",
+		"unexpected error.":                      "UnexpectedError",
+		"BAD REQUEST\nthe request was malformed": "BadRequest",
+		"the pet was not found in the store":     "",
+		"404 not found":                          "",
+		"":                                       "",
+	}
+
+	for source, expected := range tests {
+		t.Run(source, func(t *testing.T) {
+			if v := descriptionToName(source); v != expected {
+				t.Errorf("descriptionToName failed: expected %s, got %s", expected, v)
+			}
+		})
+	}
+}
+
+func TestServiceNames(t *testing.T) {
+	var tests = map[string]string{
+		"Pet Store":         "PetStoreService",
+		"Pet Store Service": "PetStoreService",
+		"pet store service": "PetStoreService",
+		"":                  "Service",
+	}
+
+	for source, expected := range tests {
+		t.Run(source, func(t *testing.T) {
+			if v := normalizeServiceName(source); v != expected {
+				t.Errorf("normalizeServiceName failed: expected %s, got %s", expected, v)
+			}
+		})
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	var tests = map[string]string{
+		"ID":          "id",
+		"URL":         "url",
+		"HTTPURL":     "http_url",
+		"userID":      "user_id",
+		"OAuth2Token": "o_auth2_token",
+		"userName":    "user_name",
+		"user_name":   "user_name",
+		"HTTPServer":  "http_server",
+		"HTTP2Server": "http2_server",
+		"getPetByID":  "get_pet_by_id",
+		"user-id":     "user_id",
+		"PENDING":     "pending",
+		"IN_PROGRESS": "in_progress",
+		"_private":    "private",
+	}
+
+	for source, expected := range tests {
+		t.Run(source, func(t *testing.T) {
+			if v := snakeCase(source); v != expected {
+				t.Errorf("snakeCase failed: expected %s, got %s", expected, v)
+			}
+		})
+	}
+}
+
+var benchmarkNames = []string{
+	"userID", "HTTPURL", "OAuth2Token", "user_name", "createdAt",
+	"shipping-address", "PENDING_REVIEW", "x-request-id", "getPetByID",
+}
+
+func BenchmarkSnakeCase(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, name := range benchmarkNames {
+			snakeCase(name)
+		}
+	}
+}
+
+func BenchmarkCamelCase(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, name := range benchmarkNames {
+			camelCase(name)
+		}
+	}
+}
+
+func BenchmarkNormalizeEnumName(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, name := range benchmarkNames {
+			normalizeEnumName(name)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package openapi
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LoadDir loads all the specs in the directory `dir` (*.yaml, *.yml and
+// *.json files, in the order of their names) and merges them into a
+// single Spec, for APIs that are split by resource without a root
+// document tying them together. Paths, definitions, parameters and
+// responses are unioned, and it is an error for two specs to declare
+// the same one differently. The info, host and other fields that
+// describe the API as a whole are taken from the first spec that sets
+// them. External references are resolved relative to each spec.
+func LoadDir(dir string, options ...Option) (*Spec, error) {
+	return loadDir(dir, NewResolver(), options...)
+}
+
+func loadDir(dir string, r *Resolver, options ...Option) (*Spec, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to read directory %s`, dir)
+	}
+
+	m := specMerger{
+		spec:    newMergedSpec(dir),
+		origins: map[string]string{},
+	}
+	var loaded int
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+
+		fn := filepath.Join(dir, entry.Name())
+		s, err := LoadFileWithResolver(fn, r, options...)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.merge(fn, s); err != nil {
+			return nil, errors.Wrapf(err, `failed to merge %s`, fn)
+		}
+		loaded++
+	}
+
+	if loaded == 0 {
+		return nil, errors.Errorf(`no specs found in directory %s`, dir)
+	}
+	return m.spec, nil
+}
+
+func newMergedSpec(dir string) *Spec {
+	return &Spec{
+		FileName:      dir,
+		Paths:         map[string]*Path{},
+		Definitions:   map[string]*Schema{},
+		Responses:     map[string]*Response{},
+		Parameters:    map[string]*Parameter{},
+		GlobalOptions: GlobalOptions{},
+		ProtoHistory:  ProtoHistory{},
+		Components: Components{
+			Schemas:    map[string]*Schema{},
+			Responses:  map[string]*Response{},
+			Parameters: map[string]*Parameter{},
+		},
+	}
+}
+
+// specMerger merges specs into one, remembering which file each
+// declaration came from to report conflicts
+type specMerger struct {
+	spec    *Spec
+	origins map[string]string
+}
+
+// declare records that the file `fn` declares `name`. It fails if
+// another file declared it differently, i.e. if `same` is false
+func (m *specMerger) declare(kind, name, fn string, same bool) error {
+	key := kind + " " + name
+	other, ok := m.origins[key]
+	if !ok {
+		m.origins[key] = fn
+		return nil
+	}
+	if !same {
+		return errors.Errorf(`%s %s is declared differently in %s and %s`, kind, name, other, fn)
+	}
+	return nil
+}
+
+func (m *specMerger) merge(fn string, s *Spec) error {
+	dst := m.spec
+	if dst.Swagger == "" {
+		dst.Swagger = s.Swagger
+	}
+	if dst.Info.Title == "" {
+		dst.Info = s.Info
+	}
+	if dst.Host == "" {
+		dst.Host = s.Host
+	}
+	if dst.BasePath == "" {
+		dst.BasePath = s.BasePath
+	}
+	if len(dst.Schemes) == 0 {
+		dst.Schemes = s.Schemes
+	}
+	if len(dst.Produces) == 0 {
+		dst.Produces = s.Produces
+	}
+	if dst.Security == nil {
+		dst.Security = s.Security
+	}
+
+	for name, v := range s.Paths {
+		if err := m.declare("path", name, fn, reflect.DeepEqual(dst.Paths[name], v)); err != nil {
+			return err
+		}
+		dst.Paths[name] = v
+	}
+	for name, v := range s.Definitions {
+		if err := m.declare("definition", name, fn, reflect.DeepEqual(dst.Definitions[name], v)); err != nil {
+			return err
+		}
+		dst.Definitions[name] = v
+	}
+	for name, v := range s.Parameters {
+		if err := m.declare("parameter", name, fn, reflect.DeepEqual(dst.Parameters[name], v)); err != nil {
+			return err
+		}
+		dst.Parameters[name] = v
+	}
+	for name, v := range s.Responses {
+		if err := m.declare("response", name, fn, reflect.DeepEqual(dst.Responses[name], v)); err != nil {
+			return err
+		}
+		dst.Responses[name] = v
+	}
+	for name, v := range s.Components.Schemas {
+		if err := m.declare("schema", name, fn, reflect.DeepEqual(dst.Components.Schemas[name], v)); err != nil {
+			return err
+		}
+		dst.Components.Schemas[name] = v
+	}
+	for name, v := range s.Components.Parameters {
+		if err := m.declare("component parameter", name, fn, reflect.DeepEqual(dst.Components.Parameters[name], v)); err != nil {
+			return err
+		}
+		dst.Components.Parameters[name] = v
+	}
+	for name, v := range s.Components.Responses {
+		if err := m.declare("component response", name, fn, reflect.DeepEqual(dst.Components.Responses[name], v)); err != nil {
+			return err
+		}
+		dst.Components.Responses[name] = v
+	}
+	for name, v := range s.GlobalOptions {
+		if err := m.declare("global option", name, fn, reflect.DeepEqual(dst.GlobalOptions[name], v)); err != nil {
+			return err
+		}
+		dst.GlobalOptions[name] = v
+	}
+
+	for _, imp := range s.ProtoImports {
+		if _, ok := m.origins["import "+imp]; ok {
+			continue
+		}
+		m.origins["import "+imp] = fn
+		dst.ProtoImports = append(dst.ProtoImports, imp)
+	}
+	for name, removed := range s.ProtoHistory {
+		dst.ProtoHistory[name] = append(dst.ProtoHistory[name], removed...)
+	}
+	dst.Extensions = append(dst.Extensions, s.Extensions...)
+	return nil
+}
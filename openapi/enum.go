@@ -0,0 +1,114 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// EnumValues represents the "enum" field. The values may be strings,
+// numbers or booleans, and are kept in their textual form, so that
+// numeric values are preserved as written
+type EnumValues []string
+
+// UnmarshalJSON decodes JSON data into EnumValues
+func (v *EnumValues) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var l []interface{}
+	if err := dec.Decode(&l); err != nil {
+		return errors.Wrap(err, `failed to decode enum values`)
+	}
+	return v.set(l)
+}
+
+// UnmarshalYAML decodes YAML data into EnumValues
+func (v *EnumValues) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var l []interface{}
+	if err := unmarshal(&l); err != nil {
+		return errors.Wrap(err, `failed to decode enum values`)
+	}
+	return v.set(l)
+}
+
+func (v *EnumValues) set(l []interface{}) error {
+	values := make(EnumValues, 0, len(l))
+	for _, elem := range l {
+		switch elem := elem.(type) {
+		case nil:
+			// null is allowed as a value of nullable enums, but it
+			// can't be represented as an enum value, so it is skipped.
+			// see alignEnumExtensions
+		case string:
+			values = append(values, elem)
+		case json.Number:
+			values = append(values, elem.String())
+		case int:
+			values = append(values, strconv.Itoa(elem))
+		case float64:
+			values = append(values, strconv.FormatFloat(elem, 'f', -1, 64))
+		case bool:
+			values = append(values, strconv.FormatBool(elem))
+		default:
+			return errors.Errorf(`invalid enum value %v (%T)`, elem, elem)
+		}
+	}
+	*v = values
+	return nil
+}
+
+// alignEnumExtensions removes the entries of x-enum-descriptions and
+// x-enum-varnames for the null values of the enum in data, the JSON of
+// a schema or parameter, as those values are skipped, so that the lists
+// stay in the same order as the enum
+func alignEnumExtensions(data []byte, descriptions, varNames *[]string) error {
+	if !bytes.Contains(data, []byte("null")) || (len(*descriptions) == 0 && len(*varNames) == 0) {
+		return nil
+	}
+
+	var raw struct {
+		Enum []interface{} `json:"enum"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.Wrap(err, `failed to decode enum values`)
+	}
+
+	nulls := map[int]struct{}{}
+	for i, elem := range raw.Enum {
+		if elem == nil {
+			nulls[i] = struct{}{}
+		}
+	}
+	if len(nulls) == 0 {
+		return nil
+	}
+
+	for _, l := range []*[]string{descriptions, varNames} {
+		var kept []string
+		for i, s := range *l {
+			if _, ok := nulls[i]; !ok {
+				kept = append(kept, s)
+			}
+		}
+		*l = kept
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes JSON data into a Parameter
+func (p *Parameter) UnmarshalJSON(data []byte) error {
+	// the alias has no methods, so that this one isn't called again
+	type parameter Parameter
+	var v parameter
+	if err := json.Unmarshal(data, &v); err != nil {
+		return errors.Wrap(err, `failed to unmarshal JSON`)
+	}
+	if err := alignEnumExtensions(data, &v.EnumDescriptions, &v.EnumVarNames); err != nil {
+		return err
+	}
+	*p = Parameter(v)
+	return nil
+}
@@ -3,12 +3,12 @@ package openapi
 import (
 	"encoding/json"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -34,6 +34,9 @@ var stringInterfaceMapType = reflect.MapOf(stringType, interfaceType)
 // This function provides the conversion routine for such cases
 func stringify(v interface{}) string {
 	switch v := v.(type) {
+	case nil:
+		// e.g. `~:` in YAML
+		return "null"
 	case string:
 		return v
 	case int:
@@ -120,7 +123,7 @@ func restoreSanityInternal(rv reflect.Value) (reflect.Value, bool) {
 			if isStringKey {
 				newKey = key
 			} else {
-				newKey = reflect.ValueOf(stringify(key.Elem().Interface()))
+				newKey = reflect.ValueOf(stringify(key.Interface()))
 			}
 
 			dst.SetMapIndex(newKey, newValue)
@@ -156,43 +159,193 @@ func parseRef(s string) (string, string, error) {
 }
 
 func isExternal(s string) bool {
-	if strings.HasPrefix(s, `google/protobuf/`) {
+	if strings.HasPrefix(s, `google/protobuf/`) || isProtoRef(s) {
 		return false
 	}
 	return strings.IndexByte(s, '#') != 0
 }
 
-func newResolver() *resolver {
-	return &resolver{}
+// references to messages in Protocol Buffers files, e.g.
+// common.proto#/Address, are left as is, as the compiler refers to the
+// messages by name and imports the files
+func isProtoRef(s string) bool {
+	i := strings.IndexByte(s, '#')
+	return i > 0 && strings.HasSuffix(s[:i], ".proto")
 }
 
-func (r *resolver) Resolve(v interface{}, options ...Option) (interface{}, error) {
-	var dir string
+// NewResolver creates a new Resolver
+func NewResolver() *Resolver {
+	return &Resolver{
+		documents: map[string]interface{}{},
+	}
+}
+
+// Resolve replaces the external references in v with their content.
+// The returned boolean is false if v did not contain any external
+// references, in which case the resolved object is the same as v
+func (r *Resolver) Resolve(v interface{}, options ...Option) (interface{}, bool, error) {
+	var base string
 	for _, o := range options {
 		switch o.Name() {
 		case optkeyDir:
-			dir = o.Value().(string)
+			base = o.Value().(string)
 		}
 	}
 
 	c := resolveCtx{
-		dir:                dir,
+		resolver:           r,
+		client:             httpClientFromOptions(options),
+		base:               base,
 		externalReferences: map[string]interface{}{},
 		cache:              map[string]interface{}{},
+		inProgress:         map[string]struct{}{},
+		hoistedNames:       map[string]string{},
+		hoistedDefinitions: map[string]interface{}{},
+		rootBase:           base,
 	}
 
-	rv, err := c.resolve(restoreSanity(reflect.ValueOf(v)))
+	sane := restoreSanity(reflect.ValueOf(v))
+	if root, ok := sane.Interface().(map[string]interface{}); ok {
+		if definitions, ok := root["definitions"].(map[string]interface{}); ok {
+			c.rootDefinitions = copyDocument(definitions).(map[string]interface{})
+		}
+	}
+
+	rv, err := c.resolve(sane)
 	if err != nil {
-		return nil, errors.Wrap(err, `failed to resolve object`)
+		return nil, false, errors.Wrap(err, `failed to resolve object`)
+	}
+
+	resolved := restoreSanity(rv).Interface()
+	if len(c.hoistedDefinitions) > 0 {
+		if err := c.addHoistedDefinitions(resolved); err != nil {
+			return nil, false, errors.Wrap(err, `failed to add definitions for cyclic references`)
+		}
+	}
+	return resolved, c.rewritten, nil
+}
+
+// adds the content of the external references that are part of a cycle
+// to the definitions of the root document. If the root document already
+// has a definition by the same name, hoistedName made sure that it is the
+// same as the external reference
+func (c *resolveCtx) addHoistedDefinitions(v interface{}) error {
+	root, ok := v.(map[string]interface{})
+	if !ok {
+		return errors.Errorf(`expected root document to be a map, got %T`, v)
+	}
+
+	definitions, ok := root["definitions"].(map[string]interface{})
+	if !ok {
+		if root["definitions"] != nil {
+			return errors.Errorf(`expected definitions to be a map, got %T`, root["definitions"])
+		}
+		definitions = map[string]interface{}{}
+		root["definitions"] = definitions
+	}
+
+	for name, def := range c.hoistedDefinitions {
+		if _, ok := definitions[name]; ok {
+			continue
+		}
+		definitions[name] = restoreSanity(reflect.ValueOf(def)).Interface()
 	}
+	return nil
+}
 
-	return restoreSanity(rv).Interface(), nil
+// returns the definition name to use for the external reference `key`,
+// which is derived from the last component of the fragment, or the file
+// name if there is no fragment. A numeric suffix is added if the name is
+// taken by another hoisted reference, or by a different definition of
+// the root document
+func (c *resolveCtx) hoistedName(key, refURL, refFragment string) (string, error) {
+	if name, ok := c.hoistedNames[key]; ok {
+		return name, nil
+	}
+
+	base := path.Base(refFragment)
+	if refFragment == "" || base == "/" || base == "." {
+		base = strings.TrimSuffix(path.Base(refURL), path.Ext(refURL))
+	}
+
+	name := base
+	for i := 2; ; i++ {
+		var taken bool
+		for _, other := range c.hoistedNames {
+			if other == name {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			other, err := c.isOtherDefinition(name, key, refURL, refFragment)
+			if err != nil {
+				return "", err
+			}
+			taken = other
+		}
+		if !taken {
+			break
+		}
+		name = base + strconv.Itoa(i)
+	}
+	c.hoistedNames[key] = name
+	return name, nil
+}
+
+// returns true if the root document has a definition named `name`, and
+// that definition is something other than the external reference `key`
+func (c *resolveCtx) isOtherDefinition(name, key, loc, refFragment string) (bool, error) {
+	def, ok := c.rootDefinitions[name]
+	if !ok {
+		return false, nil
+	}
+
+	// the definition may simply refer to the external reference, as in
+	// `Book: {$ref: 'books.yaml#/definitions/Book'}`
+	if ref, ok := refOf(reflect.ValueOf(def)); ok && isExternal(ref) {
+		base, doc := c.base, c.doc
+		c.base, c.doc = c.rootBase, ""
+		defLoc, defFragment, err := c.refLocation(ref)
+		c.base, c.doc = base, doc
+		if err != nil {
+			return false, errors.Wrapf(err, `failed to resolve location of %s`, ref)
+		}
+		return defLoc+"#"+defFragment != key, nil
+	}
+
+	// otherwise compare it with the external reference as it was
+	// written, before any of its references were resolved. the JSON
+	// encodings are compared, as YAML and JSON decode numbers differently
+	doc, err := c.loadDocument(loc)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to load %s`, loc)
+	}
+	fragment, err := jsonptr.Get(restoreSanity(reflect.ValueOf(doc)).Interface(), refFragment)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to resolve document fragment %s`, refFragment)
+	}
+
+	defJSON, err := json.Marshal(def)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to encode definition %s`, name)
+	}
+	fragmentJSON, err := json.Marshal(fragment)
+	if err != nil {
+		return false, errors.Wrapf(err, `failed to encode document fragment %s`, refFragment)
+	}
+	return string(defJSON) != string(fragmentJSON), nil
 }
 
 // note, we must use a composite type with only map[string]interface{},
 // []interface{} and interface{} as its building blocks
 func (c *resolveCtx) resolve(rv reflect.Value) (reflect.Value, error) {
 	if rv.Kind() == reflect.Interface {
+		// null values, e.g. in `type: [string, null]`, have nothing
+		// to resolve
+		if rv.IsNil() {
+			return rv, nil
+		}
 		return c.resolve(rv.Elem())
 	}
 
@@ -218,23 +371,42 @@ func (c *resolveCtx) resolve(rv reflect.Value) (reflect.Value, error) {
 			}
 
 			ref := refValue.String()
-			if isExternal(ref) {
-				refURL, refFragment, err := parseRef(ref)
+			if c.isExternal(ref) {
+				c.rewritten = true
+
+				// relative references are relative to the document that
+				// contains them, so figure out where this one really is
+				loc, refFragment, err := c.refLocation(ref)
 				if err != nil {
-					return zeroval, errors.Wrap(err, `failed to parse reference`)
+					return zeroval, errors.Wrapf(err, `failed to resolve location of %s`, ref)
+				}
+
+				// if we are already in the middle of resolving this very
+				// reference, the documents refer to each other. inlining
+				// it again would never end, so instead refer to it as an
+				// internal definition, which is added to the root document
+				key := loc + "#" + refFragment
+				if _, ok := c.inProgress[key]; ok {
+					name, err := c.hoistedName(key, loc, refFragment)
+					if err != nil {
+						return zeroval, errors.Wrapf(err, `failed to name external reference %s`, ref)
+					}
+					return reflect.ValueOf(map[string]interface{}{
+						"$ref": "#/definitions/" + name,
+					}), nil
 				}
 
 				// if we have already loaded this, don't make another
 				// roundtrip to the remote server
-				resolved, ok := c.cache[refURL]
+				resolved, ok := c.cache[loc]
 				if !ok {
 					var err error
-					resolved, err = c.loadExternal(refURL)
+					resolved, err = c.loadDocument(loc)
 					if err != nil {
 						return zeroval, errors.Wrapf(err, `failed to resolve external reference %s`, ref)
 					}
 					// remember that we have resolved this document
-					c.cache[refURL] = resolved
+					c.cache[loc] = resolved
 				}
 
 				docFragment, err := jsonptr.Get(restoreSanity(reflect.ValueOf(resolved)).Interface(), refFragment)
@@ -242,18 +414,43 @@ func (c *resolveCtx) resolve(rv reflect.Value) (reflect.Value, error) {
 					return zeroval, errors.Wrapf(err, `failed to resolve document fragment %s`, refFragment)
 				}
 
-				// recurse into docFragment
-				return c.resolve(reflect.ValueOf(docFragment))
+				// recurse into docFragment, resolving references
+				// relative to the document that we just loaded
+				base, doc := c.base, c.doc
+				c.base, c.doc = baseOf(loc), loc
+				c.inProgress[key] = struct{}{}
+				resolvedFragment, err := c.resolve(reflect.ValueOf(docFragment))
+				delete(c.inProgress, key)
+				c.base, c.doc = base, doc
+				if err != nil {
+					return zeroval, errors.Wrapf(err, `failed to resolve external reference %s`, ref)
+				}
+
+				if name, ok := c.hoistedNames[key]; ok {
+					c.hoistedDefinitions[name] = resolvedFragment.Interface()
+				}
+				return resolvedFragment, nil
 			}
 			return rv, nil
 		}
 
-		// otherwise, traverse the map
-		for _, key := range rv.MapKeys() {
+		// otherwise, traverse the map. the keys are sorted, so that
+		// cyclic references are always broken at the same place
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, key := range keys {
 			newV, err := c.resolve(rv.MapIndex(key))
 			if err != nil {
 				return zeroval, errors.Wrapf(err, `failed to resolve map element for %s`, key)
 			}
+			if key.String() == "items" && isArraySchema(rv) {
+				newV, err = c.nameItems(rv.MapIndex(key), newV)
+				if err != nil {
+					return zeroval, errors.Wrap(err, `failed to name array items`)
+				}
+			}
 			rv.SetMapIndex(key, newV)
 		}
 		return rv, nil
@@ -261,11 +458,183 @@ func (c *resolveCtx) resolve(rv reflect.Value) (reflect.Value, error) {
 	return rv, nil
 }
 
-func (c *resolveCtx) normalizePath(s string) string {
-	if c.dir == "" {
-		return s
+// when the items of an array refer to an object in an external document,
+// inlining the object would make it anonymous, and it would be named
+// after the array instead. so the resolved object is added to the
+// definitions of the root document, and the items refer to it by name
+func (c *resolveCtx) nameItems(items, resolved reflect.Value) (reflect.Value, error) {
+	ref, ok := refOf(items)
+	if !ok || !c.isExternal(ref) || !isObjectSchema(resolved) {
+		return resolved, nil
+	}
+
+	loc, refFragment, err := c.refLocation(ref)
+	if err != nil {
+		return zeroval, errors.Wrapf(err, `failed to resolve location of %s`, ref)
+	}
+
+	name, err := c.hoistedName(loc+"#"+refFragment, loc, refFragment)
+	if err != nil {
+		return zeroval, errors.Wrapf(err, `failed to name external reference %s`, ref)
+	}
+	c.hoistedDefinitions[name] = resolved.Interface()
+	return reflect.ValueOf(map[string]interface{}{
+		"$ref": "#/definitions/" + name,
+	}), nil
+}
+
+// returns the reference that the map `rv` consists of, if any
+func refOf(rv reflect.Value) (string, bool) {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Map {
+		return "", false
+	}
+
+	v := rv.MapIndex(refKey)
+	if v == zeroval {
+		return "", false
+	}
+	ref, ok := v.Interface().(string)
+	return ref, ok
+}
+
+func isArraySchema(rv reflect.Value) bool {
+	typ := rv.MapIndex(reflect.ValueOf("type"))
+	return typ != zeroval && typ.Interface() == "array"
+}
+
+func isObjectSchema(rv reflect.Value) bool {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Map {
+		return false
+	}
+	if rv.MapIndex(refKey) != zeroval {
+		return false
+	}
+	if typ := rv.MapIndex(reflect.ValueOf("type")); typ != zeroval && typ.Interface() == "object" {
+		return true
+	}
+	return rv.MapIndex(reflect.ValueOf("properties")) != zeroval
+}
+
+// returns true if `ref` needs to be inlined. besides references to
+// other documents, this includes references within an external document
+// to other parts of the same document, as these can't be resolved
+// against the root document
+func (c *resolveCtx) isExternal(ref string) bool {
+	if isExternal(ref) {
+		return true
+	}
+	return c.doc != "" && strings.HasPrefix(ref, "#")
+}
+
+// returns the location of the document that `ref` refers to, and the
+// fragment within that document
+func (c *resolveCtx) refLocation(ref string) (string, string, error) {
+	refURL, refFragment, err := parseRef(ref)
+	if err != nil {
+		return "", "", errors.Wrap(err, `failed to parse reference`)
+	}
+
+	// a reference to a fragment refers to the current document
+	if refURL == "" {
+		return c.doc, refFragment, nil
+	}
+
+	loc, err := c.location(refURL)
+	if err != nil {
+		return "", "", err
+	}
+	return loc, refFragment, nil
+}
+
+// returns the location of the referenced document, which is either
+// an absolute URL or a file path qualified by the base of the document
+// that is currently being resolved
+func (c *resolveCtx) location(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", errors.Wrapf(err, `failed to parse reference %s`, s)
+	}
+
+	if u.IsAbs() {
+		return u.String(), nil
+	}
+
+	if base, err := url.Parse(c.base); err == nil && isRemote(base) {
+		return base.ResolveReference(u).String(), nil
+	}
+
+	if c.base == "" || filepath.IsAbs(u.Path) {
+		return u.Path, nil
+	}
+	return filepath.Join(c.base, u.Path), nil
+}
+
+// returns the base against which references within the document
+// at `loc` should be resolved: the URL itself for remote documents,
+// or the directory of a local file
+func baseOf(loc string) string {
+	if u, err := url.Parse(loc); err == nil && isRemote(u) {
+		return loc
+	}
+	return filepath.Dir(loc)
+}
+
+func isRemote(u *url.URL) bool {
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// loadDocument loads the document at the location, or takes it from
+// the documents previously loaded by the resolver. resolving a document
+// modifies it, so each resolution works on its own copy
+func (c *resolveCtx) loadDocument(loc string) (interface{}, error) {
+	r := c.resolver
+	r.mu.Lock()
+	doc, ok := r.documents[loc]
+	r.mu.Unlock()
+
+	if !ok {
+		var err error
+		doc, err = c.loadExternal(loc)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		r.documents[loc] = doc
+		r.mu.Unlock()
+	}
+	return copyDocument(doc), nil
+}
+
+func copyDocument(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[key] = copyDocument(value)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(v))
+		for key, value := range v {
+			m[key] = copyDocument(value)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, value := range v {
+			l[i] = copyDocument(value)
+		}
+		return l
+	default:
+		return v
 	}
-	return filepath.Join(c.dir, s)
 }
 
 func (c *resolveCtx) loadExternal(s string) (interface{}, error) {
@@ -277,24 +646,18 @@ func (c *resolveCtx) loadExternal(s string) (interface{}, error) {
 	var src io.Reader
 	switch u.Scheme {
 	case "":
-		f, err := os.Open(c.normalizePath(u.Path))
+		f, err := os.Open(u.Path)
 		if err != nil {
 			return nil, errors.Wrapf(err, `failed to read local file %s`, u.Path)
 		}
 		defer f.Close()
 		src = f
 	case "http", "https":
-		res, err := http.Get(u.String())
+		rdr, err := fetchRemoteContent(c.client, u.String())
 		if err != nil {
 			return nil, errors.Wrapf(err, `failed to fetch remote file %s`, u.String())
 		}
-		defer res.Body.Close()
-
-		if res.StatusCode != http.StatusOK {
-			return nil, errors.Wrapf(err, `failed to fetch remote file %s`, u.String())
-		}
-
-		src = res.Body
+		src = rdr
 	default:
 		return nil, errors.Errorf(`cannot handle reference %s`, s)
 	}
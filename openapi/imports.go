@@ -0,0 +1,46 @@
+package openapi
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// ProtoImports represents the "x-proto-import" field, which lists files
+// that must be imported by the generated declaration. It may contain a
+// single file name, or a list of them
+type ProtoImports []string
+
+// UnmarshalJSON decodes JSON data into ProtoImports
+func (i *ProtoImports) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*i = []string{str}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(data, &l); err == nil {
+		*i = l
+		return nil
+	}
+
+	return errors.Errorf(`invalid x-proto-import '%s'`, data)
+}
+
+// UnmarshalYAML decodes YAML data into ProtoImports
+func (i *ProtoImports) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err == nil {
+		*i = []string{str}
+		return nil
+	}
+
+	var l []string
+	if err := unmarshal(&l); err == nil {
+		*i = l
+		return nil
+	}
+
+	return errors.New(`invalid type for x-proto-import`)
+}
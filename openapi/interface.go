@@ -1,21 +1,43 @@
 package openapi
 
 import (
+	"net/http"
+	"sync"
+
 	"github.com/NYTimes/openapi2proto/internal/option"
 )
 
 const (
-	optkeyDir = `dir`
+	optkeyDir        = `dir`
+	optkeyHTTPClient = `http-client`
 )
 
 // Option is used to pass options to several methods
 type Option = option.Option
 
-// resolver is used to resolve external references
-type resolver struct{}
+// Resolver is used to resolve external references. It keeps the
+// documents that it has loaded, so that a document referred to by
+// several specs is only fetched once if the same Resolver is used to
+// load them. It is safe for concurrent use
+type Resolver struct {
+	mu        sync.Mutex
+	documents map[string]interface{}
+}
+
 type resolveCtx struct {
-	// this is used to qualify relative paths
-	dir string
+	// this is used to look up documents loaded by previous resolutions
+	resolver *Resolver
+
+	// this is used to fetch remote documents
+	client *http.Client
+
+	// this is used to qualify relative paths. it holds the directory,
+	// or the URL of the document that is currently being resolved
+	base string
+
+	// this holds the location of the external document that is currently
+	// being resolved. it is empty while resolving the root document
+	doc string
 
 	// this holds the ready-to-be-inserted external references
 	externalReferences map[string]interface{}
@@ -23,11 +45,34 @@ type resolveCtx struct {
 	// this holds the decoded content for each URL so we don't
 	// have to keep fetching it
 	cache map[string]interface{}
+
+	// this holds the external references (URL + fragment) that are
+	// currently being resolved, so that we can detect cycles
+	inProgress map[string]struct{}
+
+	// this holds the definition names assigned to external references
+	// that are part of a cycle, and the resolved content for each name.
+	// these are added to the definitions of the root document, so that
+	// the cycle can be expressed using internal references
+	hoistedNames       map[string]string
+	hoistedDefinitions map[string]interface{}
+
+	// this holds a copy of the definitions of the root document, taken
+	// before resolution, and the base against which its references are
+	// resolved. hoisted definitions must not take the name of a different
+	// definition of the root document
+	rootBase        string
+	rootDefinitions map[string]interface{}
+
+	// this is set when any external reference was replaced by its
+	// content, i.e. the resolved object differs from the original
+	rewritten bool
 }
 
 // GlobalOptions is used to store Protocol Buffers global options,
-// such as package names
-type GlobalOptions map[string]string
+// such as package names. Values are strings or booleans, or maps for
+// options whose type is a message, which are given as aggregates
+type GlobalOptions map[string]interface{}
 
 // Spec is the base struct for containing OpenAPI spec declarations.
 type Spec struct {
@@ -42,14 +87,43 @@ type Spec struct {
 	Schemes       []string              `yaml:"schemes" json:"schemes"`
 	BasePath      string                `yaml:"basePath" json:"basePath"`
 	Produces      []string              `yaml:"produces" json:"produces"`
+	Security      []SecurityRequirement `yaml:"security" json:"security"`
 	Paths         map[string]*Path      `yaml:"paths" json:"paths"`
 	Definitions   map[string]*Schema    `yaml:"definitions" json:"definitions"`
 	Responses     map[string]*Response  `yaml:"responses" json:"responses"`
 	Parameters    map[string]*Parameter `yaml:"parameters" json:"parameters"`
+	Components    Components            `yaml:"components" json:"components"`
 	Extensions    []*Extension          `yaml:"x-extensions" json:"x-extensions"`
 	GlobalOptions GlobalOptions         `yaml:"x-global-options" json:"x-global-options"`
+	ProtoImports  ProtoImports          `yaml:"x-proto-import" json:"x-proto-import"`
+	ProtoHistory  ProtoHistory          `yaml:"x-proto-history" json:"x-proto-history"`
 }
 
+// ProtoHistory records the fields that were removed from the generated
+// messages, by the name of the message, so that their numbers and names
+// can be reserved
+type ProtoHistory map[string][]*RemovedField
+
+// RemovedField is a field that was removed from a message. Either the
+// name or the number may be omitted
+type RemovedField struct {
+	Name   string `yaml:"name" json:"name"`
+	Number int    `yaml:"number" json:"number"`
+}
+
+// Components holds the reusable objects of an OpenAPI 3 spec, which
+// are found at the top level of the spec in Swagger 2.0
+type Components struct {
+	Schemas    map[string]*Schema    `yaml:"schemas" json:"schemas"`
+	Responses  map[string]*Response  `yaml:"responses" json:"responses"`
+	Parameters map[string]*Parameter `yaml:"parameters" json:"parameters"`
+}
+
+// SecurityRequirement maps the names of the security schemes that are
+// required together to the scopes that are needed, if any. An operation
+// may list several alternative requirements
+type SecurityRequirement map[string][]string
+
 // Extension is used to define Protocol Buffer extensions from
 // within an OpenAPI spec. use `x-extentions` key.
 type Extension struct {
@@ -79,17 +153,27 @@ type Path struct {
 // Parameter is a partial representation of OpenAPI parameter type
 // (https://swagger.io/specification/#parameterObject)
 type Parameter struct {
-	Name        string     `yaml:"name" json:"name"`
-	Description string     `yaml:"description" json:"description"`
-	Enum        []string   `yaml:"enum,omitempty" json:"enum,omitempty"`
-	Format      string     `yaml:"format,omitempty" json:"format,omitempty"`
-	In          string     `yaml:"in,omitempty" json:"in,omitempty"`
-	Items       *Schema    `yaml:"items,omitempty" json:"items,omitempty"`
-	ProtoTag    protoTag   `yaml:"x-proto-tag" json:"x-proto-tag"`
-	Ref         string     `yaml:"$ref" json:"$ref"`
-	Required    bool       `yaml:"required,omitempty" json:"required,omitempty"`
-	Schema      *Schema    `yaml:"schema,omitempty" json:"schema,omitempty"` // if in == "body", then schema is present
-	Type        SchemaType `yaml:"type,omitempty" json:"type,omitempty"`
+	Name        string      `yaml:"name" json:"name"`
+	Description string      `yaml:"description" json:"description"`
+	Default     interface{} `yaml:"default,omitempty" json:"default,omitempty"`
+	Enum        EnumValues  `yaml:"enum,omitempty" json:"enum,omitempty"`
+	// descriptions of each enum value, in the same order as Enum
+	EnumDescriptions []string   `yaml:"x-enum-descriptions,omitempty" json:"x-enum-descriptions,omitempty"`
+	EnumVarNames     []string   `yaml:"x-enum-varnames,omitempty" json:"x-enum-varnames,omitempty"`
+	Format           string     `yaml:"format,omitempty" json:"format,omitempty"`
+	In               string     `yaml:"in,omitempty" json:"in,omitempty"`
+	Items            *Schema    `yaml:"items,omitempty" json:"items,omitempty"`
+	ProtoTag         protoTag   `yaml:"x-proto-tag" json:"x-proto-tag"`
+	Ref              string     `yaml:"$ref" json:"$ref"`
+	Required         bool       `yaml:"required,omitempty" json:"required,omitempty"`
+	Schema           *Schema    `yaml:"schema,omitempty" json:"schema,omitempty"` // if in == "body", then schema is present
+	Type             SchemaType `yaml:"type,omitempty" json:"type,omitempty"`
+
+	// documentation only. how arrays are serialized, either with the
+	// Swagger 2.0 collectionFormat, or the OpenAPI 3 style and explode
+	CollectionFormat string `yaml:"collectionFormat,omitempty" json:"collectionFormat,omitempty"`
+	Style            string `yaml:"style,omitempty" json:"style,omitempty"`
+	Explode          *bool  `yaml:"explode,omitempty" json:"explode,omitempty"`
 }
 
 // Parameters is a slice of request parameters for a single endpoint.
@@ -100,6 +184,23 @@ type Response struct {
 	Description string  `yaml:"description" json:"description"`
 	Schema      *Schema `yaml:"schema" json:"schema"`
 	Ref         string  `yaml:"$ref" json:"$ref"`
+
+	// documentation only. examples are keyed by their MIME type
+	Example  interface{}            `yaml:"example,omitempty" json:"example,omitempty"`
+	Examples map[string]interface{} `yaml:"examples,omitempty" json:"examples,omitempty"`
+}
+
+// RequestBody represents the request body of an OpenAPI 3 operation,
+// which replaces the body and formData parameters of Swagger 2.0
+type RequestBody struct {
+	Description string                `yaml:"description" json:"description"`
+	Required    bool                  `yaml:"required" json:"required"`
+	Content     map[string]*MediaType `yaml:"content" json:"content"`
+}
+
+// MediaType holds the schema of a request body for one content type
+type MediaType struct {
+	Schema *Schema `yaml:"schema" json:"schema"`
 }
 
 // Endpoint represents an endpoint for a path in an OpenAPI spec.
@@ -109,11 +210,18 @@ type Endpoint struct {
 	Summary       string                 `yaml:"summary" json:"summary"`
 	Description   string                 `yaml:"description" json:"description"`
 	Parameters    Parameters             `yaml:"parameters" json:"parameters"`
+	RequestBody   *RequestBody           `yaml:"requestBody" json:"requestBody"`
 	Tags          []string               `yaml:"tags" json:"tags"`
 	Responses     map[string]*Response   `yaml:"responses" json:"responses"`
 	OperationID   string                 `yaml:"operationId" json:"operationId"`
 	CustomOptions map[string]interface{} `yaml:"x-options" json:"x-options"`
 	Deprecated    bool                   `yaml:"deprecated" json:"deprecated"`
+	// the field of the response message that is sent as the HTTP
+	// response body, used as response_body in the http annotation
+	ResponseBody string `yaml:"x-grpc-response-body" json:"x-grpc-response-body"`
+	// overrides the security requirements of the spec if not nil.
+	// an empty list means that no security is required
+	Security []SecurityRequirement `yaml:"security" json:"security"`
 }
 
 // Model represents a model definition from an OpenAPI spec.
@@ -135,29 +243,81 @@ type Schema struct {
 	// is used. Note that if present, this takes precedence over other values
 	Ref string `yaml:"$ref" json:"$ref"`
 
+	Title       string `yaml:"title,omitempty" json:"title,omitempty"`
 	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// replaces the description as the comment of the generated field,
+	// message or enum
+	ProtoComment string `yaml:"x-proto-comment,omitempty" json:"x-proto-comment,omitempty"`
 	// scalar
 	// https://github.com/OAI/OpenAPI-Specification/blob/master/versions/3.0.0.md#schemaObject
 	Type   SchemaType `yaml:"type" json:"type"`
 	Format string     `yaml:"format,omitempty" json:"format,omitempty"`
-	Enum   []string   `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Enum   EnumValues `yaml:"enum,omitempty" json:"enum,omitempty"`
+	// descriptions of each enum value, in the same order as Enum
+	EnumDescriptions []string `yaml:"x-enum-descriptions,omitempty" json:"x-enum-descriptions,omitempty"`
+	// names of each enum value, in the same order as Enum, used instead
+	// of the values themselves to name the enum elements
+	EnumVarNames []string `yaml:"x-enum-varnames,omitempty" json:"x-enum-varnames,omitempty"`
+
+	// OpenAPI 3 way of saying that a value may be null
+	Nullable bool `yaml:"nullable,omitempty" json:"nullable,omitempty"`
+
+	// documentation only. proto3 has no notion of default values
+	Example interface{} `yaml:"example,omitempty" json:"example,omitempty"`
+	Default interface{} `yaml:"default,omitempty" json:"default,omitempty"`
 
 	ProtoName string   `yaml:"-" json:"-"`
 	ProtoTag  protoTag `yaml:"x-proto-tag" json:"x-proto-tag"`
 
+	// properties that are only sent by the server, or only by the client
+	ReadOnly  bool `yaml:"readOnly,omitempty" json:"readOnly,omitempty"`
+	WriteOnly bool `yaml:"writeOnly,omitempty" json:"writeOnly,omitempty"`
+
+	// properties that are still sent, but should no longer be used
+	Deprecated bool `yaml:"deprecated,omitempty" json:"deprecated,omitempty"`
+
+	// adds a packed option to the field of an array of scalar values
+	ProtoPacked *bool `yaml:"x-proto-packed,omitempty" json:"x-proto-packed,omitempty"`
+
+	// forces the name of the field generated for a property, which is
+	// otherwise the name of the property. ProtoName is used internally
+	// for the original names of parameters, so it can't carry this
+	ProtoFieldName string `yaml:"x-proto-field-name,omitempty" json:"x-proto-field-name,omitempty"`
+
+	// forces the name of the generated message, bypassing normalization
+	ProtoMessageName string `yaml:"x-proto-message-name,omitempty" json:"x-proto-message-name,omitempty"`
+
+	// forces the name of the generated enum, bypassing normalization
+	ProtoEnumName string `yaml:"x-proto-enum-name,omitempty" json:"x-proto-enum-name,omitempty"`
+
+	// options of the generated message, by the name of the option
+	ProtoMessageOptions map[string]interface{} `yaml:"x-proto-message-options,omitempty" json:"x-proto-message-options,omitempty"`
+
+	// files that the generated declaration must import for this schema
+	ProtoImports ProtoImports `yaml:"x-proto-import,omitempty" json:"x-proto-import,omitempty"`
+
+	// all the vendor extensions (x-*) of the schema, as decoded from JSON
+	Extensions map[string]interface{} `yaml:"-" json:"-"`
+
 	// objects
 	Required             []string           `yaml:"required" json:"required"`
 	Properties           map[string]*Schema `yaml:"properties" json:"properties"`
 	AdditionalProperties *Schema            `yaml:"additionalProperties" json:"additionalProperties"`
 	AllOf                []*Schema          `yaml:"allOf" json:"allOf"`
 
+	// negative constraint, which can't be expressed in protobuf
+	Not *Schema `yaml:"not,omitempty" json:"not,omitempty"`
+
 	// is an array
 	Items *Schema `yaml:"items" json:"items"`
 
-	// validation (regex pattern, max/min length)
-	Pattern   string `yaml:"pattern,omitempty" json:"pattern,omitempty"`
-	MaxLength int    `yaml:"maxLength,omitempty" json:"maxLength,omitempty"`
-	MinLength int    `yaml:"minLength,omitempty" json:"minLength,omitempty"`
-	Maximum   int    `yaml:"maximum,omitempty" json:"maximum,omitempty"`
-	Minimum   int    `yaml:"minimum,omitempty" json:"minimum,omitempty"`
+	// validation (regex pattern, max/min length, numeric bounds)
+	Pattern          string  `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	MaxLength        int     `yaml:"maxLength,omitempty" json:"maxLength,omitempty"`
+	MinLength        int     `yaml:"minLength,omitempty" json:"minLength,omitempty"`
+	Maximum          float64 `yaml:"maximum,omitempty" json:"maximum,omitempty"`
+	Minimum          float64 `yaml:"minimum,omitempty" json:"minimum,omitempty"`
+	ExclusiveMaximum bool    `yaml:"exclusiveMaximum,omitempty" json:"exclusiveMaximum,omitempty"`
+	ExclusiveMinimum bool    `yaml:"exclusiveMinimum,omitempty" json:"exclusiveMinimum,omitempty"`
+	MultipleOf       float64 `yaml:"multipleOf,omitempty" json:"multipleOf,omitempty"`
 }
@@ -6,29 +6,52 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
 )
 
-func fetchRemoteContent(u string) (io.Reader, error) {
-	res, err := http.Get(u)
+// the client used to fetch remote content, unless one is specified
+// using WithHTTPClient
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// the maximum number of bytes of the response body to include in
+// errors for unsuccessful responses
+const maxErrorBodySize = 256
+
+func httpClientFromOptions(options []Option) *http.Client {
+	cl := defaultHTTPClient
+	for _, o := range options {
+		switch o.Name() {
+		case optkeyHTTPClient:
+			if v := o.Value().(*http.Client); v != nil {
+				cl = v
+			}
+		}
+	}
+	return cl
+}
+
+func fetchRemoteContent(cl *http.Client, u string) (io.Reader, error) {
+	res, err := cl.Get(u)
 	if err != nil {
 		return nil, errors.Wrap(err, `failed to get remote content`)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return nil, errors.Errorf(`remote content responded with status %d`, res.StatusCode)
+		snippet, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+		return nil, errors.Errorf(`remote content responded with status %d: %s`, res.StatusCode, strings.TrimSpace(string(snippet)))
 	}
 
-	defer res.Body.Close()
-
 	var buf bytes.Buffer
 	if _, err := io.Copy(&buf, res.Body); err != nil {
 		return nil, errors.Wrap(err, `failed to read remote content`)
@@ -38,16 +61,41 @@ func fetchRemoteContent(u string) (io.Reader, error) {
 }
 
 // LoadFile loads an OpenAPI spec from a file, or a remote HTTP(s) location.
-// This function also resolves any external references.
-func LoadFile(fn string) (*Spec, error) {
+// This function also resolves any external references. If `fn` is a
+// local directory, the specs in it are merged, as LoadDir does.
+func LoadFile(fn string, options ...Option) (*Spec, error) {
+	return LoadFileWithResolver(fn, NewResolver(), options...)
+}
+
+// LoadFileWithResolver is like LoadFile, but uses the given Resolver to
+// resolve external references. Using the same Resolver to load several
+// specs that refer to the same external documents avoids fetching and
+// decoding them for each spec.
+func LoadFileWithResolver(fn string, r *Resolver, options ...Option) (*Spec, error) {
+	if fi, err := os.Stat(fn); err == nil && fi.IsDir() {
+		return loadDir(fn, r, options...)
+	}
+
+	// from the file name, guess how we can decode this
+	var format string
+	switch ext := strings.ToLower(path.Ext(fn)); ext {
+	case ".yaml", ".yml":
+		format = "yaml"
+	case ".json":
+		format = "json"
+	default:
+		return nil, errors.Errorf(`unsupported file extension type %s`, ext)
+	}
+
 	var src io.Reader
-	var options []Option
+	options = append([]Option(nil), options...)
 	if u, err := url.Parse(fn); err == nil && (u.Scheme == `http` || u.Scheme == `https`) {
-		rdr, err := fetchRemoteContent(u.String())
+		rdr, err := fetchRemoteContent(httpClientFromOptions(options), u.String())
 		if err != nil {
 			return nil, errors.Wrapf(err, `failed to fetch remote content %s`, fn)
 		}
 		src = rdr
+		options = append(options, WithDir(u.String()))
 	} else {
 		f, err := os.Open(fn)
 		if err != nil {
@@ -58,26 +106,55 @@ func LoadFile(fn string) (*Spec, error) {
 		options = append(options, WithDir(filepath.Dir(fn)))
 	}
 
-	// from the file name, guess how we can decode this
+	s, err := load(src, format, r, options...)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to load file %s`, fn)
+	}
+	return s, nil
+}
+
+// LoadReader loads an OpenAPI spec from an io.Reader. `format` specifies
+// how the content should be decoded, and must be either "yaml" or "json".
+// This function also resolves any references. Relative external references
+// are resolved against the current working directory, unless WithDir is
+// specified.
+func LoadReader(src io.Reader, format string, options ...Option) (*Spec, error) {
+	return load(src, strings.ToLower(format), NewResolver(), options...)
+}
+
+func load(src io.Reader, format string, r *Resolver, options ...Option) (*Spec, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, errors.Wrap(err, `failed to read content`)
+	}
+
 	var v interface{}
-	switch ext := strings.ToLower(path.Ext(fn)); ext {
-	case ".yaml", ".yml":
-		if err := yaml.NewDecoder(src).Decode(&v); err != nil {
-			return nil, errors.Wrapf(err, `failed to decode file %s`, fn)
+	switch format {
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, errors.Wrap(err, `failed to decode YAML content`)
 		}
-	case ".json":
-		if err := json.NewDecoder(src).Decode(&v); err != nil {
-			return nil, errors.Wrapf(err, `failed to decode file %s`, fn)
+	case "json":
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, errors.Wrap(err, `failed to decode JSON content`)
 		}
 	default:
-		return nil, errors.Errorf(`unsupported file extension type %s`, ext)
+		return nil, errors.Errorf(`unsupported format %s`, format)
 	}
 
-	resolved, err := newResolver().Resolve(v, options...)
+	resolved, rewritten, err := r.Resolve(v, options...)
 	if err != nil {
 		return nil, errors.Wrap(err, `failed to resolve external references`)
 	}
 
+	// if nothing had to be resolved, JSON content can be decoded as is,
+	// which saves encoding the whole spec once more. YAML content still
+	// needs the round trip below, as it can't be decoded into a Spec
+	// directly (e.g. response codes are decoded as integers)
+	if !rewritten && format == "json" {
+		return decodeSpec(data)
+	}
+
 	// We re-encode the structure here because ... it's easier this way.
 	//
 	// One way to resolve references is to create an openapi.Spec structure
@@ -127,9 +204,12 @@ func LoadFile(fn string) (*Spec, error) {
 	if err := json.NewEncoder(&buf).Encode(resolved); err != nil {
 		return nil, errors.Wrap(err, `failed to encode resolved schema`)
 	}
+	return decodeSpec(buf.Bytes())
+}
 
+func decodeSpec(data []byte) (*Spec, error) {
 	var spec Spec
-	if err := json.Unmarshal(buf.Bytes(), &spec); err != nil {
+	if err := json.Unmarshal(data, &spec); err != nil {
 		return nil, errors.Wrap(err, `failed to decode content`)
 	}
 
@@ -1,7 +1,13 @@
 package openapi_test
 
 import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/NYTimes/openapi2proto/openapi"
@@ -21,3 +27,339 @@ func TestLoadFile(t *testing.T) {
 		t.Logf("%v", s.Paths)
 	}
 }
+
+func TestLoadReaderNumericBounds(t *testing.T) {
+	const src = `swagger: "2.0"
+info:
+  title: bounds
+  version: 1.0.0
+definitions:
+  Ratio:
+    type: number
+    minimum: 0.5
+    maximum: 1
+    exclusiveMaximum: true
+    multipleOf: 0.25
+`
+	s, err := openapi.LoadReader(strings.NewReader(src), "yaml")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	ratio := s.Definitions["Ratio"]
+	if ratio == nil {
+		t.Fatal("definition Ratio not found")
+	}
+	if ratio.Minimum != 0.5 {
+		t.Errorf("expected minimum to be 0.5, got %v", ratio.Minimum)
+	}
+	if ratio.Maximum != 1 {
+		t.Errorf("expected maximum to be 1, got %v", ratio.Maximum)
+	}
+	if ratio.ExclusiveMinimum || !ratio.ExclusiveMaximum {
+		t.Errorf("expected only maximum to be exclusive, got %v/%v", ratio.ExclusiveMinimum, ratio.ExclusiveMaximum)
+	}
+	if ratio.MultipleOf != 0.25 {
+		t.Errorf("expected multipleOf to be 0.25, got %v", ratio.MultipleOf)
+	}
+}
+
+func TestLoadReaderAdditionalProperties(t *testing.T) {
+	const src = `swagger: "2.0"
+info:
+  title: additional properties
+  version: 1.0.0
+definitions:
+  Absent:
+    type: object
+  Empty:
+    type: object
+    additionalProperties: {}
+  Disallowed:
+    type: object
+    additionalProperties: false
+`
+	s, err := openapi.LoadReader(strings.NewReader(src), "yaml")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if ap := s.Definitions["Absent"].AdditionalProperties; ap != nil {
+		t.Errorf("expected additionalProperties of Absent to be nil, got %#v", ap)
+	}
+	if ap := s.Definitions["Empty"].AdditionalProperties; ap == nil || ap.IsNil() {
+		t.Errorf("expected additionalProperties of Empty to be an empty schema, got %#v", ap)
+	}
+	if ap := s.Definitions["Disallowed"].AdditionalProperties; ap == nil || !ap.IsNil() {
+		t.Errorf("expected additionalProperties of Disallowed to be a nil schema, got %#v", ap)
+	}
+}
+
+func TestLoadReaderProtoImports(t *testing.T) {
+	const src = `{
+  "swagger": "2.0",
+  "info": {"title": "imports", "version": "1.0.0"},
+  "x-proto-import": "validate/validate.proto",
+  "definitions": {
+    "Pet": {
+      "type": "object",
+      "x-proto-import": ["acme/options.proto", "acme/types.proto"]
+    }
+  }
+}`
+	s, err := openapi.LoadReader(strings.NewReader(src), "json")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if l := s.ProtoImports; len(l) != 1 || l[0] != "validate/validate.proto" {
+		t.Errorf("expected a single import for the spec, got %v", l)
+	}
+	if l := s.Definitions["Pet"].ProtoImports; len(l) != 2 || l[0] != "acme/options.proto" || l[1] != "acme/types.proto" {
+		t.Errorf("expected two imports for Pet, got %v", l)
+	}
+}
+
+func TestLoadReaderSchemaExtensions(t *testing.T) {
+	const src = `{
+  "swagger": "2.0",
+  "info": {"title": "extensions", "version": "1.0.0"},
+  "definitions": {
+    "Pet": {
+      "type": "object",
+      "properties": {
+        "price": {"type": "number", "x-money": true}
+      }
+    }
+  }
+}`
+	s, err := openapi.LoadReader(strings.NewReader(src), "json")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	pet := s.Definitions["Pet"]
+	if len(pet.Extensions) != 0 {
+		t.Errorf("expected no extensions for Pet, got %v", pet.Extensions)
+	}
+	if v, ok := pet.Properties["price"].Extensions["x-money"].(bool); !ok || !v {
+		t.Errorf("expected x-money to be true for price, got %v", pet.Properties["price"].Extensions)
+	}
+}
+
+func TestLoadReaderNullType(t *testing.T) {
+	const src = `{
+  "swagger": "2.0",
+  "info": {"title": "null types", "version": "1.0.0"},
+  "definitions": {
+    "Pet": {
+      "type": "object",
+      "properties": {
+        "quoted": {"type": ["string", "null"]},
+        "unquoted": {"type": ["string", null]}
+      }
+    }
+  }
+}`
+	s, err := openapi.LoadReader(strings.NewReader(src), "json")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for name, prop := range s.Definitions["Pet"].Properties {
+		if !prop.Type.Contains("string") || !prop.Type.Contains("null") {
+			t.Errorf("expected %s to be a nullable string, got %v", name, prop.Type)
+		}
+	}
+}
+
+func TestLoadReaderYAMLAliases(t *testing.T) {
+	const src = `swagger: "2.0"
+info:
+  title: aliases
+  version: 1.0.0
+x-responses: &responses
+  200:
+    description: ok
+  404:
+    description: not found
+x-null-keys:
+  ~: null key
+paths:
+  /pets:
+    get:
+      responses: *responses
+  /owners:
+    get:
+      responses:
+        <<: *responses
+        500:
+          description: error
+`
+	s, err := openapi.LoadReader(strings.NewReader(src), "yaml")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	wants := map[string][]string{
+		"/pets":   {"200", "404"},
+		"/owners": {"200", "404", "500"},
+	}
+	for path, codes := range wants {
+		responses := s.Paths[path].Get.Responses
+		if len(responses) != len(codes) {
+			t.Errorf("expected %d responses for %s, got %d", len(codes), path, len(responses))
+		}
+		for _, code := range codes {
+			if responses[code] == nil {
+				t.Errorf("expected response %s for %s", code, path)
+			}
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	s, err := openapi.LoadDir(filepath.Join(`..`, `fixtures`, `split_specs`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if s.Info.Title != "Split Specs" {
+		t.Errorf("expected the info of the first spec, got %q", s.Info.Title)
+	}
+	for _, name := range []string{"Error", "Owner", "Pet"} {
+		if s.Definitions[name] == nil {
+			t.Errorf("expected definition %s to be merged", name)
+		}
+	}
+	for _, name := range []string{"/owners/{id}", "/pets"} {
+		if s.Paths[name] == nil {
+			t.Errorf("expected path %s to be merged", name)
+		}
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "openapi2proto")
+		if err != nil {
+			t.Fatalf("failed to create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		files := map[string]string{
+			"a.yaml": "swagger: \"2.0\"\ndefinitions:\n  Pet:\n    type: string\n",
+			"b.yaml": "swagger: \"2.0\"\ndefinitions:\n  Pet:\n    type: integer\n",
+		}
+		for name, src := range files {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+				t.Fatalf("failed to write %s: %s", name, err)
+			}
+		}
+
+		_, err = openapi.LoadDir(dir)
+		if err == nil {
+			t.Fatalf("expected conflicting definitions to fail")
+		}
+		if !strings.Contains(err.Error(), "definition Pet is declared differently") {
+			t.Errorf("unexpected error: %s", err)
+		}
+	})
+}
+
+func TestLoadFileRemote(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: remote
+  version: 1.0.0
+definitions:
+  Pet:
+    $ref: 'pet.yaml'
+`
+	const pet = `type: object
+properties:
+  name:
+    type: string
+`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/specs/swagger.yaml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, spec)
+	})
+	mux.HandleFunc("/specs/pet.yaml", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, pet)
+	})
+	mux.HandleFunc("/specs/missing.yaml", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such spec", http.StatusNotFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	var requests int
+	cl := &http.Client{
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+	}
+
+	t.Run("custom client", func(t *testing.T) {
+		s, err := openapi.LoadFile(srv.URL+"/specs/swagger.yaml", openapi.WithHTTPClient(cl))
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if pet := s.Definitions["Pet"]; pet == nil || pet.Properties["name"] == nil {
+			t.Errorf("expected external reference to be resolved")
+		}
+		if requests != 2 {
+			t.Errorf("expected the custom client to be used for 2 requests, got %d", requests)
+		}
+	})
+
+	t.Run("shared resolver", func(t *testing.T) {
+		requests = 0
+		r := openapi.NewResolver()
+		for i := 0; i < 2; i++ {
+			s, err := openapi.LoadFileWithResolver(srv.URL+"/specs/swagger.yaml", r, openapi.WithHTTPClient(cl))
+			if err != nil {
+				t.Fatalf("%s", err)
+			}
+			if pet := s.Definitions["Pet"]; pet == nil || pet.Properties["name"] == nil {
+				t.Errorf("expected external reference to be resolved")
+			}
+		}
+		// the spec is fetched twice, but the referenced document only once
+		if requests != 3 {
+			t.Errorf("expected 3 requests, got %d", requests)
+		}
+	})
+
+	t.Run("unsuccessful response", func(t *testing.T) {
+		_, err := openapi.LoadFile(srv.URL + "/specs/missing.yaml")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "no such spec") {
+			t.Errorf("expected error to contain the status and body, got %s", err)
+		}
+	})
+}
+
+func BenchmarkLoadFile(b *testing.B) {
+	files := []string{
+		filepath.Join(`..`, `fixtures`, `accountv1-0.json`),
+		filepath.Join(`..`, `fixtures`, `semantic_api.yaml`),
+	}
+
+	for _, file := range files {
+		b.Run(filepath.Base(file), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := openapi.LoadFile(file); err != nil {
+					b.Fatalf("%s", err)
+				}
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -1,9 +1,21 @@
 package openapi
 
-import "github.com/NYTimes/openapi2proto/internal/option"
+import (
+	"net/http"
+
+	"github.com/NYTimes/openapi2proto/internal/option"
+)
 
 // WithDir returns an option to specify the directory from
-// which external references should be resolved.
+// which external references should be resolved. For remote
+// specs, this may also be the URL of the spec itself.
 func WithDir(s string) Option {
 	return option.New(optkeyDir, s)
 }
+
+// WithHTTPClient returns an option to specify the HTTP client used to
+// fetch remote specs and external references. By default, a client
+// with a 30 second timeout is used.
+func WithHTTPClient(cl *http.Client) Option {
+	return option.New(optkeyHTTPClient, cl)
+}
@@ -1,8 +1,10 @@
 package openapi
 
 import (
+	"bytes"
 	"encoding/json"
 	"reflect"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -51,6 +53,36 @@ func (s *Schema) UnmarshalJSON(data []byte) error {
 		sv.FieldByName(ft.Name).Set(fv)
 	}
 
+	if err := alignEnumExtensions(data, &s.EnumDescriptions, &s.EnumVarNames); err != nil {
+		return err
+	}
+
+	// looking for vendor extensions is only worth it if there may be
+	// any. the keys are split from their undecoded values, so that the
+	// nested schemas are not decoded again, and only the values of the
+	// extensions of this schema are decoded
+	if !bytes.Contains(data, []byte(`"x-`)) {
+		return nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.Wrap(err, `failed to unmarshal JSON`)
+	}
+	for k, rv := range raw {
+		if !strings.HasPrefix(k, "x-") {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(rv, &v); err != nil {
+			return errors.Wrapf(err, `failed to unmarshal %s`, k)
+		}
+		if s.Extensions == nil {
+			s.Extensions = map[string]interface{}{}
+		}
+		s.Extensions[k] = v
+	}
+
 	return nil
 }
 
@@ -67,9 +99,9 @@ func (s *SchemaType) UnmarshalJSON(data []byte) error {
 		return nil
 	}
 
-	var l []string
+	var l []*string
 	if err := json.Unmarshal(data, &l); err == nil {
-		*s = l
+		*s = nullTypes(l)
 		return nil
 	}
 
@@ -88,15 +120,30 @@ func (s *SchemaType) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return nil
 	}
 
-	var l []string
+	var l []*string
 	if err := unmarshal(&l); err == nil {
-		*s = l
+		*s = nullTypes(l)
 		return nil
 	}
 
 	return errors.New(`invalid type for schema type`)
 }
 
+// nullTypes converts a list of types to a SchemaType. a null element,
+// as in `type: [string, null]` where null is not quoted, stands for
+// the "null" type
+func nullTypes(l []*string) SchemaType {
+	types := make(SchemaType, len(l))
+	for i, t := range l {
+		if t == nil {
+			types[i] = "null"
+			continue
+		}
+		types[i] = *t
+	}
+	return types
+}
+
 // Empty returns true if there was no type specified
 func (s *SchemaType) Empty() bool {
 	return len(*s) == 0
@@ -2,14 +2,18 @@ package openapi2proto_test
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/NYTimes/openapi2proto"
 	"github.com/NYTimes/openapi2proto/compiler"
+	"github.com/NYTimes/openapi2proto/openapi"
 	"github.com/NYTimes/openapi2proto/protobuf"
 	"github.com/pmezard/go-difflib/difflib"
 )
@@ -22,6 +26,8 @@ type genProtoTestCase struct {
 	wrapPrimitives          bool
 	skipDeprecatedRpcs      bool
 	addAutogeneratedComment bool
+	compilerOptions         []compiler.Option
+	encoderOptions          []protobuf.Option
 }
 
 func testGenProto(t *testing.T, tests ...genProtoTestCase) {
@@ -37,8 +43,8 @@ func testGenProto(t *testing.T, tests ...genProtoTestCase) {
 			}
 
 			var generated bytes.Buffer
-			var compilerOptions []compiler.Option
-			var encoderOptions []protobuf.Option
+			compilerOptions := append([]compiler.Option(nil), test.compilerOptions...)
+			encoderOptions := append([]protobuf.Option(nil), test.encoderOptions...)
 			if test.options {
 				compilerOptions = append(compilerOptions, compiler.WithAnnotation(true))
 			}
@@ -98,6 +104,25 @@ func TestNetwork(t *testing.T) {
 	})
 }
 
+// maps all schemas with x-money to a shared Money message, and the ones
+// with x-timestamp to google.protobuf.Timestamp
+func moneyTypeMapper() compiler.TypeMapper {
+	money := protobuf.NewMessage("Money")
+	money.AddField(protobuf.NewField(protobuf.StringType, "currency_code", 1))
+	money.AddField(protobuf.NewField(protobuf.Int64Type, "units", 2))
+	money.AddField(protobuf.NewField(protobuf.Int32Type, "nanos", 3))
+
+	return func(name string, s *openapi.Schema) (protobuf.Type, bool, error) {
+		if v, ok := s.Extensions["x-money"].(bool); ok && v {
+			return money, true, nil
+		}
+		if _, ok := s.Extensions["x-timestamp"]; ok {
+			return protobuf.TimestampType, true, nil
+		}
+		return nil, false, nil
+	}
+}
+
 func TestGenerateProto(t *testing.T) {
 	tests := []genProtoTestCase{
 		{
@@ -196,6 +221,1049 @@ func TestGenerateProto(t *testing.T) {
 		{
 			fixturePath: "fixtures/global_responses.yaml",
 		},
+		{
+			fixturePath: "fixtures/param_locations.yaml",
+		},
+		{
+			fixturePath: "fixtures/param_locations.yaml",
+			wantProto:   "fixtures/param_locations-ignored.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithIgnoreParamLocations([]string{"header", "cookie"}),
+			},
+		},
+		{
+			fixturePath: "fixtures/nullable.yaml",
+		},
+		{
+			fixturePath: "fixtures/examples.yaml",
+		},
+		{
+			fixturePath: "fixtures/message_name.yaml",
+		},
+		{
+			fixturePath: "fixtures/enum_descriptions.yaml",
+		},
+		{
+			fixturePath: "fixtures/cats.yaml",
+			wantProto:   "fixtures/cats-imports.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithExtraImports([]string{"validate/validate.proto", "google/protobuf/empty.proto", "validate/validate.proto"}),
+			},
+		},
+		{
+			options:     true,
+			fixturePath: "fixtures/custom_options.yaml",
+			wantProto:   "fixtures/custom_options-tags.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithTagsAsComment(true),
+				compiler.WithTagsAsOption("tags"),
+			},
+		},
+		{
+			fixturePath: "fixtures/response_refs.yaml",
+		},
+		{
+			fixturePath: "fixtures/scalar_responses.yaml",
+		},
+		{
+			fixturePath: "fixtures/response_refs.yaml",
+			wantProto:   "fixtures/response_refs-concrete.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithConcreteEmptyMessages(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/scalar_responses.yaml",
+			wantProto:   "fixtures/scalar_responses-concrete.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithConcreteEmptyMessages(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/external_cycle/swagger.yaml",
+		},
+		{
+			fixturePath: "fixtures/external_cycle/collision.yaml",
+		},
+		{
+			fixturePath: "fixtures/relative_refs/a.yaml",
+		},
+		{
+			fixturePath: "fixtures/external_items/swagger.yaml",
+		},
+		{
+			fixturePath: "fixtures/nested_refs.yaml",
+		},
+		{
+			fixturePath: "fixtures/read_write_only.yaml",
+		},
+		{
+			fixturePath: "fixtures/read_write_only.yaml",
+			wantProto:   "fixtures/read_write_only-split.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithSplitReadWriteOnly(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/singularize.yaml",
+			wantProto:   "fixtures/singularize.proto",
+		},
+		{
+			fixturePath: "fixtures/singularize.yaml",
+			wantProto:   "fixtures/singularize-inflector.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithInflector(compiler.Singularize),
+			},
+		},
+		{
+			fixturePath: "fixtures/error_responses.yaml",
+			wantProto:   "fixtures/error_responses.proto",
+		},
+		{
+			fixturePath: "fixtures/error_responses.yaml",
+			wantProto:   "fixtures/error_responses-messages.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithErrorResponses(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/error_responses.yaml",
+			wantProto:   "fixtures/error_responses-suffixes.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithErrorResponses(true),
+				compiler.WithRequestSuffix("Req"),
+				compiler.WithResponseSuffix("Resp"),
+			},
+		},
+		{
+			fixturePath: "fixtures/integer_enums.yaml",
+		},
+		{
+			fixturePath: "fixtures/cats.yaml",
+			wantProto:   "fixtures/cats-service_name.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithServiceName("CatAPI"),
+			},
+		},
+		{
+			fixturePath: "fixtures/additional_properties.yaml",
+		},
+		{
+			fixturePath: "fixtures/tags.yaml",
+		},
+		{
+			fixturePath: "fixtures/tags.yaml",
+			wantProto:   "fixtures/tags-filtered.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithIncludeTags([]string{"pets"}),
+				compiler.WithExcludeTags([]string{"internal"}),
+			},
+		},
+		{
+			fixturePath: "fixtures/additional_properties_false.yaml",
+		},
+		{
+			fixturePath: "fixtures/additional_properties_false.yaml",
+			wantProto:   "fixtures/additional_properties_false-comment.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithAdditionalPropertiesComment(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/json_name.yaml",
+		},
+		{
+			fixturePath: "fixtures/json_name.yaml",
+			wantProto:   "fixtures/json_name-option.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithJSONNameOption(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/json_name.yaml",
+			wantProto:   "fixtures/json_name-proto2.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithJSONNameOption(true),
+			},
+			encoderOptions: []protobuf.Option{
+				protobuf.WithSyntax("proto2"),
+			},
+		},
+		{
+			fixturePath: "fixtures/endpoint_name_collision.yaml",
+		},
+		{
+			fixturePath: "fixtures/prune_unused.yaml",
+		},
+		{
+			fixturePath: "fixtures/prune_unused.yaml",
+			wantProto:   "fixtures/prune_unused-pruned.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithPruneUnusedMessages(true),
+				compiler.WithWellKnownTypes(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/prune_unused.yaml",
+			wantProto:   "fixtures/prune_unused-roots.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithPruneUnusedMessages(true),
+				compiler.WithPruneRoots([]string{"Store"}),
+				compiler.WithWellKnownTypes(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/prune_unused.yaml",
+			wantProto:   "fixtures/prune_unused-messages-only.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithMessagesOnly(true),
+				compiler.WithPruneUnusedMessages(true),
+				compiler.WithPruneRoots([]string{"Pet"}),
+				compiler.WithWellKnownTypes(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/nested_arrays.yaml",
+		},
+		{
+			fixturePath: "fixtures/integer_formats.yaml",
+		},
+		{
+			fixturePath: "fixtures/integer_formats.yaml",
+			wantProto:   "fixtures/integer_formats-wrapped.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithWrapPrimitives(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/integer_formats.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithDefaultIntegerType("int32"),
+			},
+		},
+		{
+			fixturePath: "fixtures/integer_formats.yaml",
+			wantProto:   "fixtures/integer_formats-int64.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithDefaultIntegerType("int64"),
+			},
+		},
+		{
+			fixturePath: "fixtures/sfixed_formats.yaml",
+		},
+		{
+			fixturePath: "fixtures/well_known_types.yaml",
+		},
+		{
+			fixturePath: "fixtures/well_known_types.yaml",
+			wantProto:   "fixtures/well_known_types-enabled.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithWellKnownTypes(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/array_definitions.yaml",
+		},
+		{
+			fixturePath: "fixtures/parameter_tags.yaml",
+		},
+		{
+			fixturePath: "fixtures/binary_formats.yaml",
+		},
+		{
+			fixturePath: "fixtures/most_popular.json",
+			wantProto:   "fixtures/most_popular-http_comment.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithHTTPCommentOnRPC(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/scalar_parameter_refs.yaml",
+		},
+		{
+			fixturePath: "fixtures/scalar_parameter_refs.yaml",
+			wantProto:   "fixtures/scalar_parameter_refs-proto2.proto",
+			encoderOptions: []protobuf.Option{
+				protobuf.WithSyntax("proto2"),
+			},
+		},
+		{
+			fixturePath: "fixtures/packed.yaml",
+		},
+		{
+			fixturePath: "fixtures/packed.yaml",
+			wantProto:   "fixtures/packed-wrapped.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithWrapPrimitives(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/components.yaml",
+		},
+		{
+			fixturePath: "fixtures/security.yaml",
+		},
+		{
+			fixturePath: "fixtures/security.yaml",
+			wantProto:   "fixtures/security-comment.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithSecurityAsComment(true),
+				compiler.WithSecurityAsOption("auth.required"),
+			},
+		},
+		{
+			fixturePath: "fixtures/proto_import.yaml",
+		},
+		{
+			fixturePath: "fixtures/empty_objects.yaml",
+		},
+		{
+			fixturePath: "fixtures/empty_objects.yaml",
+			wantProto:   "fixtures/empty_objects-struct.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithEmptyObjectAsStruct(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/top_level_enums.yaml",
+		},
+		{
+			fixturePath: "fixtures/top_level_enums.yaml",
+			wantProto:   "fixtures/top_level_enums-hoisted.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithTopLevelEnums(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/schema_title.yaml",
+		},
+		{
+			fixturePath: "fixtures/schema_title.yaml",
+			wantProto:   "fixtures/schema_title-title.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithUseSchemaTitle(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/type_mapper.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithTypeMapper(moneyTypeMapper()),
+			},
+		},
+		{
+			fixturePath: "fixtures/comments.yaml",
+		},
+		{
+			fixturePath: "fixtures/proto_comment.yaml",
+		},
+		{
+			fixturePath: "fixtures/exclude_definitions.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithExcludeDefinitions([]string{"InternalAudit"}),
+			},
+		},
+		{
+			fixturePath: "fixtures/proto_enum_name.yaml",
+		},
+		{
+			fixturePath: "fixtures/field_number_base.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithFieldNumberBase(100),
+			},
+		},
+		{
+			fixturePath: "fixtures/not.yaml",
+		},
+		{
+			fixturePath: "fixtures/response_examples.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithExamplesAsComments(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/proto_history.yaml",
+		},
+		{
+			fixturePath: "fixtures/empty_type.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithEmptyType("common.Empty"),
+				compiler.WithExtraImports([]string{"common/empty.proto"}),
+			},
+		},
+		{
+			fixturePath: "fixtures/empty_type.yaml",
+			wantProto:   "fixtures/empty_type-declaration-order.proto",
+			encoderOptions: []protobuf.Option{
+				protobuf.WithServiceRPCOrder("declaration"),
+			},
+		},
+		{
+			fixturePath: "fixtures/parameter_refs.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithJSONNameOption(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/parameter_refs.yaml",
+			wantProto:   "fixtures/parameter_refs-no-headers.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithIgnoreParamLocations([]string{"header"}),
+			},
+		},
+		{
+			fixturePath: "fixtures/deprecated_fields.yaml",
+		},
+		{
+			fixturePath: "fixtures/enum_varnames.yaml",
+		},
+		{
+			fixturePath: "fixtures/enum_collisions.yaml",
+		},
+		{
+			fixturePath: "fixtures/null_enum_values.yaml",
+		},
+		{
+			fixturePath: "fixtures/map_refs.yaml",
+		},
+		{
+			fixturePath: "fixtures/not_refs.yaml",
+		},
+		{
+			options:     true,
+			fixturePath: "fixtures/query_arrays.yaml",
+		},
+		{
+			fixturePath: "fixtures/query_styles.yaml",
+		},
+		{
+			fixturePath: "fixtures/proto_field_name.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithJSONNameOption(true),
+			},
+		},
+		{
+			options:     true,
+			fixturePath: "fixtures/response_body.yaml",
+		},
+		{
+			fixturePath: "fixtures/nullable_types.yaml",
+		},
+		{
+			fixturePath: "fixtures/aggregate_options.yaml",
+		},
+		{
+			fixturePath: "fixtures/split_specs",
+			wantProto:   "fixtures/split_specs.proto",
+		},
+		{
+			fixturePath: "fixtures/proto_refs.yaml",
+		},
+		{
+			fixturePath: "fixtures/message_options.yaml",
+		},
+		{
+			fixturePath: "fixtures/recursive_definitions.yaml",
+		},
+		{
+			options:     true,
+			fixturePath: "fixtures/path_parameter_types.yaml",
+		},
+		{
+			fixturePath: "fixtures/yaml_anchors.yaml",
+			compilerOptions: []compiler.Option{
+				compiler.WithErrorResponses(true),
+			},
+		},
+		{
+			// aliases must compile exactly like the content they stand for
+			fixturePath: "fixtures/yaml_anchors-expanded.yaml",
+			wantProto:   "fixtures/yaml_anchors.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithErrorResponses(true),
+			},
+		},
+		{
+			options:     true,
+			fixturePath: "fixtures/cats.yaml",
+			wantProto:   "fixtures/cats-messages-only.proto",
+			compilerOptions: []compiler.Option{
+				compiler.WithMessagesOnly(true),
+			},
+		},
+		{
+			fixturePath: "fixtures/request_body.yaml",
+		},
+		{
+			fixturePath: "fixtures/proto2.yaml",
+			encoderOptions: []protobuf.Option{
+				protobuf.WithSyntax("proto2"),
+			},
+		},
 	}
 	testGenProto(t, tests...)
 }
+
+func TestTranspileReader(t *testing.T) {
+	src, err := os.Open("fixtures/cats.yaml")
+	if err != nil {
+		t.Fatal("unable to open test fixture: ", err)
+	}
+	defer src.Close()
+
+	var generated bytes.Buffer
+	if err := openapi2proto.TranspileReader(&generated, src, "yaml"); err != nil {
+		t.Errorf(`failed to transpile: %s`, err)
+		return
+	}
+
+	want, err := ioutil.ReadFile("fixtures/cats.proto")
+	if err != nil {
+		t.Fatal("unable to open test fixture: ", err)
+	}
+
+	if string(want) != generated.String() {
+		t.Errorf("TranspileReader output differs from fixtures/cats.proto:\n%s", generated.String())
+	}
+}
+
+func TestDuplicateProtoTags(t *testing.T) {
+	src, err := os.Open("fixtures/duplicate_tags.yaml")
+	if err != nil {
+		t.Fatal("unable to open test fixture: ", err)
+	}
+	defer src.Close()
+
+	var generated bytes.Buffer
+	err = openapi2proto.TranspileReader(&generated, src, "yaml")
+	if err == nil {
+		t.Errorf("expected an error for duplicate tags, got:\n%s", generated.String())
+		return
+	}
+
+	const want = `fields name and nickname in message Pet both use tag 2`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %q", want, err.Error())
+	}
+}
+
+func TestExcludedDefinitionReference(t *testing.T) {
+	src, err := os.Open("fixtures/exclude_definitions.yaml")
+	if err != nil {
+		t.Fatal("unable to open test fixture: ", err)
+	}
+	defer src.Close()
+
+	var generated bytes.Buffer
+	err = openapi2proto.TranspileReader(&generated, src, "yaml", openapi2proto.WithCompilerOptions(
+		compiler.WithExcludeDefinitions([]string{"Item"}),
+	))
+	if err == nil {
+		t.Errorf("expected an error for a reference to an excluded definition, got:\n%s", generated.String())
+		return
+	}
+
+	const want = `reference #/definitions/Item refers to definition Item, which is excluded`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %q", want, err.Error())
+	}
+}
+
+func TestReferenceCycle(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: Reference Cycle
+  version: 1.0.0
+definitions:
+  A:
+    $ref: '#/definitions/B'
+  B:
+    $ref: '#/definitions/A'
+  Pet:
+    type: object
+    properties:
+      a:
+        $ref: '#/definitions/A'
+`
+	var generated bytes.Buffer
+	err := openapi2proto.TranspileReader(&generated, strings.NewReader(spec), "yaml")
+	if err == nil {
+		t.Fatalf("expected an error for a reference cycle, got:\n%s", generated.String())
+	}
+
+	const want = `reference cycle #/definitions/A -> #/definitions/B -> #/definitions/A`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %q", want, err.Error())
+	}
+}
+
+func TestOperationIDTransformer(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: Operation IDs
+  version: 1.0.0
+paths:
+  /users/{id}:
+    get:
+      operationId: %s
+      parameters:
+        - name: id
+          in: path
+          required: true
+          type: string
+      responses:
+        "204":
+          description: ok
+`
+	// keeps operationIds as they are, apart from dots
+	verbatim := func(s string) string {
+		return strings.ToUpper(s[:1]) + strings.Replace(s[1:], ".", "_", -1)
+	}
+
+	tests := map[string]string{
+		"getUserByID": "rpc GetUserByID(",
+		"list_pets":   "rpc List_pets(",
+		"v2.search":   "rpc V2_search(",
+	}
+	for opID, want := range tests {
+		var generated bytes.Buffer
+		err := openapi2proto.TranspileReader(&generated, strings.NewReader(fmt.Sprintf(spec, opID)), "yaml", openapi2proto.WithCompilerOptions(compiler.WithOperationIDTransformer(verbatim)))
+		if err != nil {
+			t.Errorf("%s: failed to transpile: %s", opID, err)
+			continue
+		}
+		if !strings.Contains(generated.String(), want) {
+			t.Errorf("%s: expected output to contain %q, got:\n%s", opID, want, generated.String())
+		}
+	}
+
+	var generated bytes.Buffer
+	err := openapi2proto.TranspileReader(&generated, strings.NewReader(fmt.Sprintf(spec, "v2.search")), "yaml", openapi2proto.WithCompilerOptions(compiler.WithOperationIDTransformer(strings.ToLower)))
+	if err == nil {
+		t.Fatalf("expected an error for an invalid rpc name, got:\n%s", generated.String())
+	}
+	const want = `invalid rpc name "v2.search" for operationId v2.search`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected error to contain %q, got %q", want, err.Error())
+	}
+}
+
+func TestInvalidEmptyType(t *testing.T) {
+	for _, name := range []string{"common Empty", "1Empty", "common..Empty", "common.Empty."} {
+		var generated bytes.Buffer
+		err := openapi2proto.Transpile(&generated, "fixtures/empty_type.yaml", openapi2proto.WithCompilerOptions(compiler.WithEmptyType(name)))
+		if err == nil {
+			t.Errorf("expected an error for empty type %q, got:\n%s", name, generated.String())
+			continue
+		}
+		if !strings.Contains(err.Error(), `invalid empty type`) {
+			t.Errorf("unexpected error for empty type %q: %s", name, err)
+		}
+	}
+}
+
+func TestTopLevelEnumConflict(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: Top Level Enums
+  version: 1.0.0
+paths: {}
+definitions:
+  Pet:
+    type: object
+    properties:
+      status:
+        type: string
+        enum:
+          - available
+          - sold
+  PetStatus:
+    type: string
+    enum:
+      - alive
+      - dead
+`
+	var generated bytes.Buffer
+	err := openapi2proto.TranspileReader(&generated, strings.NewReader(spec), "yaml", openapi2proto.WithCompilerOptions(compiler.WithTopLevelEnums(true)))
+	if err == nil {
+		t.Fatalf("expected an error, got:\n%s", generated.String())
+	}
+	if !strings.Contains(err.Error(), `enum PetStatus declared in Pet conflicts with the definition of the same name`) {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestTopLevelEnumCollision(t *testing.T) {
+	var generated bytes.Buffer
+	err := openapi2proto.Transpile(&generated, "fixtures/top_level_enum_collision.yaml", openapi2proto.WithCompilerOptions(compiler.WithTopLevelEnums(true)))
+	if err == nil {
+		t.Fatalf("expected an error, got:\n%s", generated.String())
+	}
+	if !strings.Contains(err.Error(), `enum PetTypeStatus declared in PetType conflicts with the enum of the same name declared in Pet`) {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestFieldNumberOverflow(t *testing.T) {
+	var generated bytes.Buffer
+	err := openapi2proto.Transpile(&generated, "fixtures/field_number_base.yaml", openapi2proto.WithCompilerOptions(compiler.WithFieldNumberBase(536870911)))
+	if err == nil {
+		t.Fatalf("expected an error, got:\n%s", generated.String())
+	}
+	if !strings.Contains(err.Error(), `must be at most 536870911`) {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestFieldNameConflict(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: Field Names
+  version: 1.0.0
+paths: {}
+definitions:
+  Pet:
+    type: object
+    properties:
+      name:
+        type: string
+      fooBar:
+        type: string
+        x-proto-field-name: name
+`
+	var generated bytes.Buffer
+	err := openapi2proto.TranspileReader(&generated, strings.NewReader(spec), "yaml")
+	if err == nil {
+		t.Fatalf("expected an error, got:\n%s", generated.String())
+	}
+	if !strings.Contains(err.Error(), `properties fooBar and name of message Pet are both compiled to field name`) {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestInvalidSuffixes(t *testing.T) {
+	tests := map[string][]compiler.Option{
+		"empty request suffix":  {compiler.WithRequestSuffix("")},
+		"empty response suffix": {compiler.WithResponseSuffix("")},
+		"same suffixes":         {compiler.WithRequestSuffix("Msg"), compiler.WithResponseSuffix("Msg")},
+	}
+	for name, options := range tests {
+		var generated bytes.Buffer
+		err := openapi2proto.Transpile(&generated, "fixtures/cats.yaml", openapi2proto.WithCompilerOptions(options...))
+		if err == nil {
+			t.Errorf("%s: expected an error, got:\n%s", name, generated.String())
+			continue
+		}
+		if !strings.Contains(err.Error(), `suffix`) {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+	}
+}
+
+func TestPruneWithoutRpcsOrRoots(t *testing.T) {
+	tests := map[string]compiler.Option{
+		"messages only": compiler.WithMessagesOnly(true),
+		"skip rpcs":     compiler.WithSkipRpcs(true),
+	}
+	for name, option := range tests {
+		var generated bytes.Buffer
+		err := openapi2proto.Transpile(&generated, "fixtures/cats.yaml", openapi2proto.WithCompilerOptions(
+			option,
+			compiler.WithPruneUnusedMessages(true),
+		))
+		if err == nil {
+			t.Errorf("%s: expected an error, got:\n%s", name, generated.String())
+			continue
+		}
+		if !strings.Contains(err.Error(), `requires prune roots`) {
+			t.Errorf("%s: unexpected error: %s", name, err)
+		}
+	}
+}
+
+func TestInvalidDefaultIntegerType(t *testing.T) {
+	for _, name := range []string{"", "uint64", "integer"} {
+		var generated bytes.Buffer
+		err := openapi2proto.Transpile(&generated, "fixtures/integer_formats.yaml", openapi2proto.WithCompilerOptions(compiler.WithDefaultIntegerType(name)))
+		if err == nil {
+			t.Errorf("expected an error for default integer type %q, got:\n%s", name, generated.String())
+			continue
+		}
+		if !strings.Contains(err.Error(), `invalid default integer type`) {
+			t.Errorf("unexpected error for default integer type %q: %s", name, err)
+		}
+	}
+}
+
+func TestInvalidResponseBody(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: Response Body
+  version: 1.0.0
+paths:
+  /pets/{id}:
+    %s:
+      operationId: pet
+      x-grpc-response-body: nickname
+      responses:
+        "%s":
+          description: the pet
+          schema:
+            type: object
+            properties:
+              name:
+                type: string
+`
+	tests := map[string]string{
+		"get":    fmt.Sprintf(spec, "get", "200"),
+		"delete": fmt.Sprintf(spec, "delete", "204"),
+	}
+	wants := map[string]string{
+		"get":    `response message PetResponse has no field nickname`,
+		"delete": `the endpoint has no response body`,
+	}
+
+	for name, spec := range tests {
+		var generated bytes.Buffer
+		err := openapi2proto.TranspileReader(&generated, strings.NewReader(spec), "yaml", openapi2proto.WithCompilerOptions(compiler.WithAnnotation(true)))
+		if err == nil {
+			t.Errorf("%s: expected an error, got:\n%s", name, generated.String())
+			continue
+		}
+		if !strings.Contains(err.Error(), wants[name]) {
+			t.Errorf("%s: expected error to contain %q, got %q", name, wants[name], err)
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	const spec = `swagger: "2.0"
+info:
+  title: Warnings
+  version: 1.0.0
+paths:
+  /foo/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          type: string
+          required: true
+      responses:
+        200:
+          description: ok
+  /foo/id:
+    get:
+      responses:
+        200:
+          description: ok
+definitions:
+  Pet:
+    type: object
+    properties:
+      name:
+        type: string
+        pattern: '^[a-z]+$'
+        maxLength: 10
+      anything:
+        type: [string, integer]
+      notCat:
+        not:
+          $ref: '#/definitions/Cat'
+  Cat:
+    type: object
+`
+	var warnings []string
+	handler := compiler.WithWarningHandler(func(w compiler.Warning) {
+		warnings = append(warnings, w.String())
+	})
+
+	var generated bytes.Buffer
+	if err := openapi2proto.TranspileReader(&generated, strings.NewReader(spec), "yaml", openapi2proto.WithCompilerOptions(handler)); err != nil {
+		t.Fatalf("failed to transpile: %s", err)
+	}
+
+	expected := []string{
+		"#/definitions/Pet -> property anything: type [string, integer] can't be expressed in protobuf, compiled to google.protobuf.Any",
+		"#/definitions/Pet -> property name: validation keywords pattern, maxLength are ignored",
+		"#/definitions/Pet -> property notCat: `not` can't be expressed in protobuf, compiled to google.protobuf.Any",
+		"GET /foo/{id}: conflicts with an existing rpc named GetFooId, renamed to GetFooId2",
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("unexpected warnings:\n%s", strings.Join(warnings, "\n"))
+	}
+}
+
+func TestWarningLocationAfterIgnoredError(t *testing.T) {
+	// the items of the map values fail to compile, but the error is
+	// ignored, and must not leave its location behind
+	const spec = `swagger: "2.0"
+info:
+  title: Breadcrumbs
+  version: 1.0.0
+paths: {}
+definitions:
+  A:
+    type: object
+    properties:
+      groups:
+        type: object
+        additionalProperties:
+          type: array
+          items:
+            type: object
+            properties:
+              tags:
+                type: array
+                x-proto-packed: true
+                items:
+                  type: string
+  B:
+    type: object
+    properties:
+      name:
+        type: string
+        pattern: '^[a-z]+$'
+`
+	var warnings []string
+	handler := compiler.WithWarningHandler(func(w compiler.Warning) {
+		warnings = append(warnings, w.String())
+	})
+
+	var generated bytes.Buffer
+	if err := openapi2proto.TranspileReader(&generated, strings.NewReader(spec), "yaml", openapi2proto.WithCompilerOptions(handler)); err != nil {
+		t.Fatalf("failed to transpile: %s", err)
+	}
+
+	expected := []string{
+		"#/definitions/B -> property name: validation keywords pattern are ignored",
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("unexpected warnings:\n%s", strings.Join(warnings, "\n"))
+	}
+}
+
+func TestExtractDefaults(t *testing.T) {
+	spec, err := openapi.LoadFile("fixtures/proto2.yaml")
+	if err != nil {
+		t.Fatalf("failed to load spec: %s", err)
+	}
+	defaults, err := compiler.ExtractDefaults(spec)
+	if err != nil {
+		t.Fatalf("failed to extract defaults: %s", err)
+	}
+
+	want := map[string]interface{}{
+		"ListPetsRequest.limit": float64(20),
+		"Pet.status":            "available",
+		"Pet.vaccinated":        false,
+		"Pet.weight":            1.5,
+	}
+	if !reflect.DeepEqual(defaults, want) {
+		t.Errorf("expected defaults %v, got %v", want, defaults)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	// titles collide a lot in this spec, so the output depends on the
+	// order in which definitions are compiled
+	options := []openapi2proto.Option{
+		openapi2proto.WithCompilerOptions(compiler.WithUseSchemaTitle(true)),
+	}
+
+	var want []byte
+	for i := 0; i < 50; i++ {
+		var generated bytes.Buffer
+		if err := openapi2proto.Transpile(&generated, "fixtures/accountv1-0.json", options...); err != nil {
+			t.Fatalf("failed to transpile: %s", err)
+		}
+
+		if i == 0 {
+			want = generated.Bytes()
+			continue
+		}
+		if !bytes.Equal(want, generated.Bytes()) {
+			t.Fatalf("output of run %d differs from the first run:\n%s", i+1, generated.String())
+		}
+	}
+}
+
+func TestErrorBreadcrumbs(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{
+			spec: `swagger: "2.0"
+info:
+  title: Breadcrumbs
+  version: 1.0.0
+paths: {}
+definitions:
+  Pet:
+    type: object
+    properties:
+      owner:
+        type: object
+        properties:
+          tags:
+            type: array
+            items:
+              type: string
+            x-proto-packed: true
+`,
+			want: `failed to compile #/definitions/Pet -> property owner -> property tags: `,
+		},
+		{
+			spec: `swagger: "2.0"
+info:
+  title: Breadcrumbs
+  version: 1.0.0
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      responses:
+        "200":
+          description: the pets
+          schema:
+            $ref: "#/definitions/Missing"
+`,
+			want: `failed to compile GET /pets: `,
+		},
+	}
+
+	for _, test := range tests {
+		var generated bytes.Buffer
+		err := openapi2proto.TranspileReader(&generated, strings.NewReader(test.spec), "yaml")
+		if err == nil {
+			t.Errorf("expected an error, got:\n%s", generated.String())
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("expected error to contain %q, got %q", test.want, err.Error())
+		}
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	files := []string{
+		filepath.Join(`fixtures`, `accountv1-0.json`),
+		filepath.Join(`fixtures`, `semantic_api.yaml`),
+	}
+
+	for _, file := range files {
+		spec, err := openapi.LoadFile(file)
+		if err != nil {
+			b.Fatalf("%s", err)
+		}
+
+		b.Run(filepath.Base(file), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := compiler.Compile(spec); err != nil {
+					b.Fatalf("%s", err)
+				}
+			}
+		})
+	}
+}
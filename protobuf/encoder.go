@@ -17,6 +17,10 @@ import (
 func NewEncoder(dst io.Writer, options ...Option) *Encoder {
 	indent := `    `
 	autogeneratedComment := false
+	syntax := "proto3"
+	blankLines := 1
+	rpcOrder := "name"
+	var generatedHeader string
 	for _, o := range options {
 		switch o.Name() {
 		case optkeyIndent:
@@ -24,6 +28,18 @@ func NewEncoder(dst io.Writer, options ...Option) *Encoder {
 
 		case optkeyAutogenerateComment:
 			autogeneratedComment = o.Value().(bool)
+
+		case optkeySyntax:
+			syntax = o.Value().(string)
+
+		case optkeyBlankLines:
+			blankLines = o.Value().(int)
+
+		case optkeyServiceRPCOrder:
+			rpcOrder = o.Value().(string)
+
+		case optkeyGeneratedHeader:
+			generatedHeader = o.Value().(string)
 		}
 	}
 
@@ -31,7 +47,21 @@ func NewEncoder(dst io.Writer, options ...Option) *Encoder {
 		dst:    dst,
 		indent: indent,
 		autogeneratedComment: autogeneratedComment,
+		syntax: syntax,
+		blankLines: blankLines,
+		rpcOrder: rpcOrder,
+		generatedHeader: generatedHeader,
+	}
+}
+
+// Encode is a convenience function that encodes a protobuf.Package
+// and returns the textual representation as a byte slice
+func Encode(p *Package, options ...Option) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, options...).Encode(p); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
 }
 
 // creates a new encoder that emits to a different destination,
@@ -77,7 +107,39 @@ func indent(dst io.Writer, src io.Reader, indentStr string) (int64, error) {
 }
 
 func (e *Encoder) comment(c string) (int64, error) {
-	return prefix(e.dst, strings.NewReader(c), `// `, true)
+	return writeComment(e.dst, c)
+}
+
+// writeComment writes c as a line comment, one comment line per line
+// of c, so that descriptions keep their paragraphs and lists as is.
+// Trailing whitespace is removed from each line, blank lines within c
+// are written as an empty "//" line, and blank lines at its start or
+// end are dropped.
+func writeComment(dst io.Writer, c string) (int64, error) {
+	lines := strings.Split(c, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	for len(lines) > 0 && lines[0] == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		if line == "" {
+			buf.WriteString("//")
+			continue
+		}
+		buf.WriteString("// ")
+		buf.WriteString(line)
+	}
+	return buf.WriteTo(dst)
 }
 
 // EncodeField encods the message field
@@ -89,8 +151,29 @@ func (e *Encoder) EncodeField(v *Field) error {
 	fmt.Fprintf(e.dst, "\n")
 	if v.repeated {
 		fmt.Fprintf(e.dst, "repeated ")
+	} else if e.syntax == "proto2" {
+		// map fields can't have labels
+		if _, ok := v.Type().(*Map); !ok {
+			if v.required {
+				fmt.Fprintf(e.dst, "required ")
+			} else {
+				fmt.Fprintf(e.dst, "optional ")
+			}
+		}
+	}
+	fmt.Fprintf(e.dst, "%s %s = %d", e.typeName(v.Type()), v.Name(), v.Index())
+
+	var options []string
+	if e.syntax == "proto2" && v.defaultValue != nil && !v.repeated {
+		options = append(options, "default = "+stringify(v.defaultValue))
 	}
-	fmt.Fprintf(e.dst, "%s %s = %d;", v.Type().Name(), v.Name(), v.Index())
+	for _, o := range v.options {
+		options = append(options, o.name+" = "+stringify(o.value))
+	}
+	if len(options) > 0 {
+		fmt.Fprintf(e.dst, " [%s]", strings.Join(options, ", "))
+	}
+	fmt.Fprintf(e.dst, ";")
 	return nil
 }
 
@@ -109,15 +192,119 @@ func (e *Encoder) writeBlock(name string, src io.Reader) error {
 	return nil
 }
 
+// typeParents records the names of the messages that each type
+// declared in the package is nested in. The same type may be
+// declared in more than one place
+func typeParents(p *Package) map[Type][][]string {
+	parents := map[Type][][]string{}
+	var walk func(Type, []string)
+	walk = func(t Type, chain []string) {
+		for _, child := range getChildren(t) {
+			parents[child] = append(parents[child], chain)
+			if m, ok := child.(*Message); ok {
+				walk(m, append(append([]string(nil), chain...), m.name))
+			}
+		}
+	}
+	walk(p, nil)
+	return parents
+}
+
+// typeName returns the name used to refer to `t` from the current scope.
+// Types nested in a message that is not an enclosing scope are qualified
+// with the names of the messages that they are nested in (e.g. Foo.Bar)
+func (e *Encoder) typeName(t Type) string {
+	if m, ok := t.(*Map); ok {
+		return fmt.Sprintf(`map<%s, %s>`, e.typeName(m.key), e.typeName(m.value))
+	}
+
+	var qualified []string
+	for _, chain := range e.parents[t] {
+		// skip the enclosing scopes that we share with the type,
+		// as names declared in them are visible from here
+		var i int
+		for i < len(chain) && i < len(e.scope) && chain[i] == e.scope[i] {
+			i++
+		}
+		if i == len(chain) {
+			return t.Name()
+		}
+		if qualified == nil || len(chain)-i < len(qualified) {
+			qualified = chain[i:]
+		}
+	}
+
+	if qualified == nil {
+		return t.Name()
+	}
+	return strings.Join(append(append([]string(nil), qualified...), t.Name()), ".")
+}
+
+// reservedStatements returns the reserved statements of a message: one
+// for the numbers, with consecutive numbers written as ranges, and one
+// for the names
+func reservedStatements(m *Message) []string {
+	var stmts []string
+
+	numbers := append([]int(nil), m.reservedNumbers...)
+	sort.Ints(numbers)
+	var ranges []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] <= numbers[j]+1 {
+			j++
+		}
+		if numbers[i] == numbers[j] {
+			ranges = append(ranges, strconv.Itoa(numbers[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d to %d", numbers[i], numbers[j]))
+		}
+		i = j + 1
+	}
+	if len(ranges) > 0 {
+		stmts = append(stmts, "reserved "+strings.Join(ranges, ", ")+";")
+	}
+
+	names := append([]string(nil), m.reservedNames...)
+	sort.Strings(names)
+	var quoted []string
+	for i, name := range names {
+		if i > 0 && name == names[i-1] {
+			continue
+		}
+		quoted = append(quoted, strconv.Quote(name))
+	}
+	if len(quoted) > 0 {
+		stmts = append(stmts, "reserved "+strings.Join(quoted, ", ")+";")
+	}
+	return stmts
+}
+
 // EncodeMessage encodes a Message object
 func (e *Encoder) EncodeMessage(v *Message) error {
 	var buf bytes.Buffer
 	subEncoder := e.subEncoder(&buf)
+	subEncoder.scope = append(append([]string(nil), e.scope...), v.name)
+	if err := subEncoder.encodeMessageOptions(v.options); err != nil {
+		return errors.Wrapf(err, `failed to encode options for message %s`, v.Name())
+	}
+	if buf.Len() > 0 && len(v.children) > 0 {
+		fmt.Fprintf(&buf, "\n")
+	}
 	if err := subEncoder.encodeChildren(v); err != nil {
 		return errors.Wrap(err, `failed to encode message definitions`)
 	}
 
-	sort.Slice(v.fields, func(i, j int) bool {
+	if reserved := reservedStatements(v); len(reserved) > 0 {
+		if buf.Len() > 0 {
+			fmt.Fprintf(&buf, "\n")
+		}
+		for _, stmt := range reserved {
+			fmt.Fprintf(&buf, "\n%s", stmt)
+		}
+	}
+
+	sort.SliceStable(v.fields, func(i, j int) bool {
 		return v.fields[i].index < v.fields[j].index
 	})
 
@@ -141,6 +328,39 @@ func (e *Encoder) EncodeMessage(v *Message) error {
 	return nil
 }
 
+// encodeMessageOptions encodes the options of a message, in the order
+// of their names
+func (e *Encoder) encodeMessageOptions(options []*MessageOption) error {
+	sorted := append([]*MessageOption(nil), options...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].name < sorted[j].name
+	})
+
+	for _, o := range sorted {
+		name := o.name
+		if !strings.HasPrefix(name, "(") {
+			name = "(" + name + ")"
+		}
+
+		switch v := o.value.(type) {
+		case map[string]interface{}:
+			var buf bytes.Buffer
+			if err := e.subEncoder(&buf).encodeAggregate(v); err != nil {
+				return errors.Wrapf(err, `failed to encode value of option %s`, o.name)
+			}
+			if err := e.writeBlock("option "+name+" =", &buf); err != nil {
+				return errors.Wrap(err, `failed to write option block`)
+			}
+			fmt.Fprintf(e.dst, ";")
+		case string, bool, float64, float32, int, int64, int32, uint, uint64, uint32:
+			fmt.Fprintf(e.dst, "\noption %s = %s;", name, stringify(v))
+		default:
+			return errors.Errorf(`unsupported value for option %s (%T)`, o.name, v)
+		}
+	}
+	return nil
+}
+
 // EncodeHTTPAnnotation encods a HTTPAnnotation object
 func (e *Encoder) EncodeHTTPAnnotation(a *HTTPAnnotation) error {
 	var buf bytes.Buffer
@@ -148,6 +368,9 @@ func (e *Encoder) EncodeHTTPAnnotation(a *HTTPAnnotation) error {
 	if len(a.body) > 0 {
 		fmt.Fprintf(&buf, "\nbody: %s", strconv.Quote(a.body))
 	}
+	if len(a.responseBody) > 0 {
+		fmt.Fprintf(&buf, "\nresponse_body: %s", strconv.Quote(a.responseBody))
+	}
 
 	if err := e.writeBlock("option (google.api.http) =", &buf); err != nil {
 		return errors.Wrap(err, `failed to write http annotation block`)
@@ -242,7 +465,7 @@ func (e *Encoder) EncodeRPC(r *RPC) error {
 		}
 	}
 
-	name := fmt.Sprintf("rpc %s(%s) returns (%s)", r.name, r.parameter.Name(), r.response.Name())
+	name := fmt.Sprintf("rpc %s(%s) returns (%s)", r.name, e.typeName(r.parameter), e.typeName(r.response))
 	if err := e.writeBlock(name, &buf); err != nil {
 		return errors.Wrap(err, `failed to write rpc block`)
 	}
@@ -258,10 +481,13 @@ func (e *Encoder) EncodeService(s *Service) error {
 	var buf bytes.Buffer
 	subEncoder := e.subEncoder(&buf)
 
-	sort.Slice(s.rpcs, func(i, j int) bool {
-		return s.rpcs[i].Name() < s.rpcs[j].Name()
-	})
-	for i, rpc := range s.rpcs {
+	rpcs := append([]*RPC(nil), s.rpcs...)
+	if e.rpcOrder == "name" {
+		sort.Slice(rpcs, func(i, j int) bool {
+			return rpcs[i].Name() < rpcs[j].Name()
+		})
+	}
+	for i, rpc := range rpcs {
 		if i > 0 {
 			fmt.Fprintf(&buf, "\n")
 		}
@@ -280,7 +506,18 @@ func (e *Encoder) EncodeService(s *Service) error {
 func (e *Encoder) EncodeEnum(v *Enum) error {
 	var buf bytes.Buffer
 	for i, elem := range v.elements {
-		fmt.Fprintf(&buf, "\n%s = %d;", elem, i)
+		number := i
+		if ee, ok := elem.(*EnumElement); ok {
+			if len(ee.comment) > 0 {
+				fmt.Fprintf(&buf, "\n")
+				writeComment(&buf, ee.comment)
+			}
+			if ee.explicit {
+				number = ee.number
+			}
+			elem = ee.name
+		}
+		fmt.Fprintf(&buf, "\n%s = %d;", elem, number)
 	}
 
 	if len(v.comment) > 0 {
@@ -347,8 +584,20 @@ func (e *Encoder) EncodeExtension(ext *Extension) error {
 
 // EncodeGlobalOption encodes a GlobationOption object
 func (e *Encoder) EncodeGlobalOption(o *GlobalOption) error {
+	if o.aggregate != nil {
+		var buf bytes.Buffer
+		if err := e.subEncoder(&buf).encodeAggregate(o.aggregate); err != nil {
+			return errors.Wrapf(err, `failed to encode value of option %s`, o.name)
+		}
+		if err := e.writeBlock("option "+o.name+" =", &buf); err != nil {
+			return errors.Wrap(err, `failed to write option block`)
+		}
+		fmt.Fprintf(e.dst, ";")
+		return nil
+	}
+
 	var value string
-	if o.value == "true" || o.value == "false" {
+	if o.literal || o.value == "true" || o.value == "false" {
 		value = o.value
 	} else {
 		value = strconv.Quote(o.value)
@@ -357,15 +606,81 @@ func (e *Encoder) EncodeGlobalOption(o *GlobalOption) error {
 	return nil
 }
 
+// encodeAggregate encodes the fields of an aggregate option value in
+// the protobuf text format, one per line, in the order of their names.
+// the elements of lists are encoded as repeated fields
+func (e *Encoder) encodeAggregate(m map[string]interface{}) error {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		values, ok := m[name].([]interface{})
+		if !ok {
+			values = []interface{}{m[name]}
+		}
+
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				var buf bytes.Buffer
+				if err := e.subEncoder(&buf).encodeAggregate(v); err != nil {
+					return errors.Wrapf(err, `failed to encode field %s`, name)
+				}
+				if err := e.writeBlock(name+":", &buf); err != nil {
+					return errors.Wrapf(err, `failed to write field %s`, name)
+				}
+			case string, bool, float64, float32, int, int64, int32, uint, uint64, uint32:
+				fmt.Fprintf(e.dst, "\n%s: %s", name, stringify(v))
+			default:
+				return errors.Errorf(`unsupported value for field %s (%T)`, name, v)
+			}
+		}
+	}
+	return nil
+}
+
 // EncodePackage encodes a Package
 func (e *Encoder) EncodePackage(p *Package) error {
+	// the header is kept apart from the rest, so that it is not taken
+	// for the comment of whatever follows
+	if e.generatedHeader != "" {
+		fmt.Fprintf(e.dst, "%s\n\n", e.generatedHeader)
+	}
 	if e.autogeneratedComment {
 		fmt.Fprintf(e.dst, "// This file is autogenerated by openapi2proto. DO NOT CHANGE IT MANUALLY\n")
 	}
-	fmt.Fprintf(e.dst, "syntax = \"proto3\";")
+	switch e.syntax {
+	case "proto2", "proto3":
+	default:
+		return errors.Errorf(`unknown syntax %s`, e.syntax)
+	}
+	switch e.rpcOrder {
+	case "name", "declaration":
+	default:
+		return errors.Errorf(`unknown rpc order %s`, e.rpcOrder)
+	}
+	if e.blankLines < 0 {
+		return errors.Errorf(`invalid number of blank lines between declarations: %d`, e.blankLines)
+	}
+	if len(p.comment) > 0 {
+		if e.autogeneratedComment {
+			fmt.Fprintf(e.dst, "\n")
+		}
+		e.comment(p.comment)
+		fmt.Fprintf(e.dst, "\n\n")
+	}
+	fmt.Fprintf(e.dst, "syntax = %s;", strconv.Quote(e.syntax))
 	fmt.Fprintf(e.dst, "\n")
 	fmt.Fprintf(e.dst, "\npackage %s;", p.name)
 
+	if e.parents == nil {
+		e.parents = typeParents(p)
+		defer func() { e.parents = nil }()
+	}
+
 	if len(p.imports) > 0 {
 		fmt.Fprintf(e.dst, "\n")
 		sort.Strings(p.imports)
@@ -414,7 +729,7 @@ func (e *Encoder) encodeChildren(t Type) error {
 		return nil
 	}
 
-	sort.Slice(children, func(i, j int) bool {
+	sort.SliceStable(children, func(i, j int) bool {
 		ci := children[i]
 		cj := children[j]
 		if ci.Priority() == cj.Priority() {
@@ -424,9 +739,16 @@ func (e *Encoder) encodeChildren(t Type) error {
 		return ci.Priority() < cj.Priority()
 	})
 
+	// declarations nested in messages are always separated by a
+	// single blank line. top level declarations use the configured number
+	separator := "\n"
+	if _, ok := t.(*Package); ok {
+		separator = strings.Repeat("\n", e.blankLines)
+	}
+
 	for i, child := range children {
 		if i > 0 {
-			fmt.Fprintf(e.dst, "\n")
+			fmt.Fprintf(e.dst, "%s", separator)
 		}
 
 		if err := e.EncodeType(child); err != nil {
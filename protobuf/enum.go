@@ -7,11 +7,41 @@ func NewEnum(name string) *Enum {
 	}
 }
 
-// AddElement adds a new enum element
+// AddElement adds a new enum element. `n` may be either a plain
+// value such as a string, or an *EnumElement
 func (e *Enum) AddElement(n interface{}) {
 	e.elements = append(e.elements, n)
 }
 
+// PrependElement adds a new enum element before the existing elements
+func (e *Enum) PrependElement(n interface{}) {
+	e.elements = append([]interface{}{n}, e.elements...)
+}
+
+// NewEnumElement creates an EnumElement object
+func NewEnumElement(name string) *EnumElement {
+	return &EnumElement{
+		name: name,
+	}
+}
+
+// Name returns the name of this enum element
+func (e *EnumElement) Name() string {
+	return e.name
+}
+
+// SetComment sets the comment associated with this enum element
+func (e *EnumElement) SetComment(s string) {
+	e.comment = s
+}
+
+// SetNumber sets the number of this enum element. By default, enum
+// elements are numbered by their position in the enum, starting at 0
+func (e *EnumElement) SetNumber(n int) {
+	e.number = n
+	e.explicit = true
+}
+
 // Name returns the name of this type
 func (e *Enum) Name() string {
 	return e.name
@@ -0,0 +1,182 @@
+package protobuf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ServiceFileName is the name of the file that EncodeToFS writes the
+// service and the extensions to
+const ServiceFileName = "service.proto"
+
+// EncodeToFS encodes the package into multiple files under `dir`,
+// instead of one large declaration. Each top level message and enum is
+// written to its own file, named after the type in snake case (e.g.
+// FooBar becomes foo_bar.proto), while the service and any extensions
+// are written to service.proto.
+//
+// Every file carries the comment of the package, declares the same
+// package and global options, imports the files of the top level types
+// that it refers to, and also carries the imports of the original
+// package.
+func EncodeToFS(dir string, p *Package, options ...Option) error {
+	files := map[string]string{}  // top level type name -> file name
+	owners := map[string]string{} // file name -> top level type name
+	var service []Type
+	for _, child := range p.children {
+		switch child.(type) {
+		case *Service, *Extension:
+			service = append(service, child)
+			continue
+		}
+
+		fn := protoFileName(child.Name())
+		if owner, ok := owners[fn]; ok {
+			return errors.Errorf(`types %s and %s would both be written to %s`, owner, child.Name(), fn)
+		}
+		owners[fn] = child.Name()
+		files[child.Name()] = fn
+	}
+	if owner, ok := owners[ServiceFileName]; ok {
+		return errors.Errorf(`type %s conflicts with %s`, owner, ServiceFileName)
+	}
+
+	// types nested in messages declared in other files must be
+	// qualified, so look at the whole package to find their parents
+	parents := typeParents(p)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, `failed to create directory %s`, dir)
+	}
+
+	write := func(fn string, children []Type) error {
+		// figure out which of the other files we need to import
+		refs := map[string]struct{}{}
+		for _, child := range children {
+			collectReferences(child, parents, refs)
+		}
+
+		imports := append([]string(nil), p.imports...)
+		for name := range refs {
+			if dep, ok := files[name]; ok && dep != fn {
+				imports = append(imports, dep)
+			}
+		}
+		sort.Strings(imports)
+
+		sub := &Package{
+			comment:  p.comment,
+			name:     p.name,
+			imports:  imports,
+			children: children,
+			options:  p.options,
+		}
+
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, options...)
+		e.parents = parents
+		if err := e.Encode(sub); err != nil {
+			return errors.Wrapf(err, `failed to encode %s`, fn)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, `failed to write %s`, fn)
+		}
+		return nil
+	}
+
+	for _, child := range p.children {
+		fn, ok := files[child.Name()]
+		if !ok {
+			continue
+		}
+		if err := write(fn, []Type{child}); err != nil {
+			return err
+		}
+	}
+
+	if hasServiceContent(service) {
+		if err := write(ServiceFileName, service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasServiceContent returns true if any of the given types would
+// produce output. Services without rpcs are not encoded
+func hasServiceContent(types []Type) bool {
+	for _, t := range types {
+		if s, ok := t.(*Service); ok && len(s.rpcs) == 0 {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// collectReferences records the names of the top level types that are
+// referred to from within `t`. For nested types, the name of the top
+// level message that they are declared in is recorded
+func collectReferences(t Type, parents map[Type][][]string, refs map[string]struct{}) {
+	add := func(t Type) {
+		if t == nil {
+			return
+		}
+		if chains := parents[t]; len(chains) > 0 && len(chains[0]) > 0 {
+			refs[chains[0][0]] = struct{}{}
+			return
+		}
+		name := t.Name()
+		if i := strings.IndexByte(name, '.'); i > -1 {
+			name = name[:i]
+		}
+		refs[name] = struct{}{}
+	}
+
+	switch x := t.(type) {
+	case *Message:
+		for _, f := range x.fields {
+			if m, ok := f.Type().(*Map); ok {
+				add(m.key)
+				add(m.value)
+				continue
+			}
+			add(f.Type())
+		}
+		for _, child := range x.children {
+			collectReferences(child, parents, refs)
+		}
+	case *Service:
+		for _, r := range x.rpcs {
+			add(r.parameter)
+			add(r.response)
+		}
+	}
+}
+
+// protoFileName converts a type name such as FooBar or HTTPRequest into
+// a file name like foo_bar.proto or http_request.proto
+func protoFileName(name string) string {
+	var buf bytes.Buffer
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && runes[i-1] != '_' && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				buf.WriteByte('_')
+			}
+			buf.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	buf.WriteString(".proto")
+	return buf.String()
+}
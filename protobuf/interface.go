@@ -18,13 +18,21 @@ type Option = option.Option
 
 // Builtin types
 var (
-	BoolType   = newBuiltin("bool")
-	BytesType  = newBuiltin("bytes")
-	DoubleType = newBuiltin("double")
-	FloatType  = newBuiltin("float")
-	Int32Type  = newBuiltin("int32")
-	Int64Type  = newBuiltin("int64")
-	StringType = newBuiltin("string")
+	BoolType     = newBuiltin("bool")
+	BytesType    = newBuiltin("bytes")
+	DoubleType   = newBuiltin("double")
+	Fixed32Type  = newBuiltin("fixed32")
+	Fixed64Type  = newBuiltin("fixed64")
+	FloatType    = newBuiltin("float")
+	Int32Type    = newBuiltin("int32")
+	Int64Type    = newBuiltin("int64")
+	SFixed32Type = newBuiltin("sfixed32")
+	SFixed64Type = newBuiltin("sfixed64")
+	SInt32Type   = newBuiltin("sint32")
+	SInt64Type   = newBuiltin("sint64")
+	StringType   = newBuiltin("string")
+	UInt32Type   = newBuiltin("uint32")
+	UInt64Type   = newBuiltin("uint64")
 )
 
 // Boxed types
@@ -38,6 +46,14 @@ var (
 	Int64ValueType  = NewMessage("google.protobuf.Int64Value")
 	NullValueType   = NewMessage("google.protobuf.NullValue")
 	StringValueType = NewMessage("google.protobuf.StringValue")
+	UInt32ValueType = NewMessage("google.protobuf.UInt32Value")
+	UInt64ValueType = NewMessage("google.protobuf.UInt64Value")
+)
+
+// Well known types
+var (
+	DurationType  = NewMessage("google.protobuf.Duration")
+	TimestampType = NewMessage("google.protobuf.Timestamp")
 )
 
 // value type
@@ -60,16 +76,28 @@ type Encoder struct {
 	dst                    io.Writer
 	indent                 string
 	autogeneratedComment   bool
+	syntax                 string
+	blankLines             int
+	rpcOrder               string
+	generatedHeader        string
+
+	// the names of the messages enclosing the declarations being
+	// encoded, and the enclosing messages of every declared type
+	scope   []string
+	parents map[Type][][]string
 }
 
 // GlobalOption represents a Protocol Buffers global option
 type GlobalOption struct {
-	name  string
-	value string
+	name      string
+	value     string
+	literal   bool
+	aggregate map[string]interface{}
 }
 
 // Package represnets a Protocol Buffers Package.
 type Package struct {
+	comment  string
 	name     string
 	imports  []string
 	children []Type
@@ -82,6 +110,12 @@ type Type interface {
 	Priority() int
 }
 
+// Node is anything that is visited by Package.Walk: the types declared
+// in the package, the fields of messages and the rpcs of services
+type Node interface {
+	Name() string
+}
+
 // Container is a special type that can have child types
 type Container interface {
 	Type
@@ -96,6 +130,15 @@ type Enum struct {
 	name     string
 }
 
+// EnumElement represents a value of a Protocol Buffers enum type
+// that carries a comment, or an explicit number
+type EnumElement struct {
+	comment  string
+	name     string
+	number   int
+	explicit bool
+}
+
 // Map represents a Protocol Buffers map type
 type Map struct {
 	key   Type
@@ -107,19 +150,37 @@ type Builtin string
 
 // Message is a composite type
 type Message struct {
-	children []Type
-	comment  string
-	fields   []*Field
-	name     string
+	children        []Type
+	comment         string
+	fields          []*Field
+	name            string
+	options         []*MessageOption
+	reservedNames   []string
+	reservedNumbers []int
+}
+
+// MessageOption represents a custom option of a message
+type MessageOption struct {
+	name  string
+	value interface{}
 }
 
 // Field is a field in a Message
 type Field struct {
-	comment  string
-	index    int
-	name     string
-	repeated bool
-	typ      Type
+	comment      string
+	defaultValue interface{}
+	index        int
+	name         string
+	options      []*FieldOption
+	repeated     bool
+	required     bool
+	typ          Type
+}
+
+// FieldOption represents an option of a field, such as json_name
+type FieldOption struct {
+	name  string
+	value interface{}
 }
 
 // ExtensionField is a field in an extended field
@@ -153,9 +214,10 @@ type Service struct {
 
 // HTTPAnnotation represents a google.api.http option
 type HTTPAnnotation struct {
-	method string
-	path   string
-	body   string
+	method       string
+	path         string
+	body         string
+	responseBody string
 }
 
 // RPCOption represents simple rpc options
@@ -21,3 +21,13 @@ func (m *Map) Name() string {
 func (m *Map) Priority() int {
 	return -1
 }
+
+// Key returns the type of the keys of this map
+func (m *Map) Key() Type {
+	return m.key
+}
+
+// Value returns the type of the values of this map
+func (m *Map) Value() Type {
+	return m.value
+}
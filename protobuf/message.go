@@ -33,6 +33,29 @@ func (f *Field) Name() string {
 	return f.name
 }
 
+// SetJSONName sets the name used for this field in the JSON mapping,
+// which is encoded as the json_name option of the field
+func (f *Field) SetJSONName(s string) {
+	f.AddOption("json_name", s)
+}
+
+// AddOption adds an option to this field, which is encoded in the
+// bracketed list after the field number, e.g. [deprecated = true].
+// Custom options must be given with their parentheses, e.g.
+// "(validate.rules).string.min_len". Strings are quoted, while
+// numbers and booleans are encoded as is
+func (f *Field) AddOption(name string, value interface{}) {
+	f.options = append(f.options, &FieldOption{
+		name:  name,
+		value: value,
+	})
+}
+
+// SetName sets the name of this field
+func (f *Field) SetName(s string) {
+	f.name = s
+}
+
 // Type returns the Type of this field
 func (f *Field) Type() Type {
 	return f.typ
@@ -53,6 +76,24 @@ func (f *Field) SetRepeated(b bool) {
 	f.repeated = b
 }
 
+// SetRequired sets if this field is required. This is only
+// reflected in the output when encoding with proto2 syntax
+func (f *Field) SetRequired(b bool) {
+	f.required = b
+}
+
+// SetDefault sets the default value for this field. This is only
+// reflected in the output when encoding with proto2 syntax
+func (f *Field) SetDefault(v interface{}) {
+	f.defaultValue = v
+}
+
+// Default returns the default value for this field, or nil if the
+// field has none
+func (f *Field) Default() interface{} {
+	return f.defaultValue
+}
+
 // NewMessage creates a new Message
 func NewMessage(name string) *Message {
 	return &Message{
@@ -65,6 +106,18 @@ func (m *Message) AddType(t Type) {
 	m.children = append(m.children, t)
 }
 
+// AddOption adds an option to this message, which is encoded at the
+// top of the message body, e.g. `option (my.msg_opt) = true;`. The name
+// is that of a custom option, and is given without parentheses unless
+// it refers to a field of the option, e.g. "(my.msg_opt).enabled".
+// Maps are encoded as aggregate values
+func (m *Message) AddOption(name string, value interface{}) {
+	m.options = append(m.options, &MessageOption{
+		name:  name,
+		value: value,
+	})
+}
+
 // Name returns the name of this type
 func (m *Message) Name() string {
 	return m.name
@@ -85,7 +138,25 @@ func (m *Message) AddField(f *Field) {
 	m.fields = append(m.fields, f)
 }
 
+// Fields returns the fields of this message
+func (m *Message) Fields() []*Field {
+	return m.fields
+}
+
 // SetComment sets the comment associated to this message
 func (m *Message) SetComment(s string) {
 	m.comment = s
 }
+
+// AddReservedNumber reserves a field number, e.g. the number of a field
+// that was removed, so that it can't be used again. Consecutive numbers
+// are encoded as ranges
+func (m *Message) AddReservedNumber(n int) {
+	m.reservedNumbers = append(m.reservedNumbers, n)
+}
+
+// AddReservedName reserves a field name, e.g. the name of a field that
+// was removed, so that it can't be used again
+func (m *Message) AddReservedName(s string) {
+	m.reservedNames = append(m.reservedNames, s)
+}
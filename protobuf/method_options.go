@@ -13,6 +13,13 @@ func (a *HTTPAnnotation) SetBody(s string) {
 	a.body = s
 }
 
+// SetResponseBody sets the response_body optional parameter, which
+// names the field of the response message that is sent as the HTTP
+// response body instead of the whole message
+func (a *HTTPAnnotation) SetResponseBody(s string) {
+	a.responseBody = s
+}
+
 // NewRPCOption create an RPCOption object
 func NewRPCOption(name string, value interface{}) *RPCOption {
 	return &RPCOption{
@@ -5,6 +5,10 @@ import "github.com/NYTimes/openapi2proto/internal/option"
 const (
 	optkeyIndent              = "indent"
 	optkeyAutogenerateComment = "autogenerate-message"
+	optkeySyntax              = "syntax"
+	optkeyBlankLines          = "blank-lines"
+	optkeyServiceRPCOrder     = "service-rpc-order"
+	optkeyGeneratedHeader     = "generated-header"
 )
 
 // WithIndent creates a new Option to control the indentation
@@ -17,4 +21,53 @@ func WithIndent(s string) Option {
 // head of the generated proto file
 func WithAutogeneratedComment(b bool) Option {
 	return option.New(optkeyAutogenerateComment, b)
-}
\ No newline at end of file
+}
+
+// WithSyntax creates a new Option to specify the Protocol Buffers
+// syntax to be used. Can be either "proto3" (default) or "proto2".
+// When "proto2" is specified, fields are labeled as optional/required,
+// and default values are emitted.
+func WithSyntax(s string) Option {
+	return option.New(optkeySyntax, s)
+}
+
+// WithBlankLinesBetweenMessages creates a new Option to specify the
+// number of blank lines between top level declarations (messages, enums,
+// extensions and the service). The default is 1.
+//
+// The spacing of the rest of the output is fixed: the header (syntax,
+// package, imports and global options) is separated from the declarations
+// by a single blank line, and declarations nested in a message as well as
+// commented fields and rpcs are each preceded by a single blank line.
+func WithBlankLinesBetweenMessages(n int) Option {
+	return option.New(optkeyBlankLines, n)
+}
+
+// WithServiceRPCOrder creates a new Option to specify the order of the
+// rpcs of a service. Can be either "name" (default), to sort them by
+// name, or "declaration", to keep the order in which they were added to
+// the service. The compiler adds rpcs path by path, sorted by path, and
+// in the order get, put, post, patch, delete within a path
+func WithServiceRPCOrder(s string) Option {
+	return option.New(optkeyServiceRPCOrder, s)
+}
+
+// WithGeneratedHeader creates a new Option to start the encoded
+// definition with the comment that marks generated files in the Go
+// ecosystem, so that linters and reviewers can skip them, e.g.
+//
+//	// Code generated by openapi2proto v1.2.0 from spec.yaml. DO NOT EDIT.
+//
+// `spec` names the spec that the definition was generated from, and
+// `version` the version of the tool, which is left out if empty. Both
+// are written as given, so the header does not change between runs
+func WithGeneratedHeader(spec, version string) Option {
+	header := "// Code generated by openapi2proto"
+	if version != "" {
+		header += " " + version
+	}
+	if spec != "" {
+		header += " from " + spec
+	}
+	return option.New(optkeyGeneratedHeader, header+". DO NOT EDIT.")
+}
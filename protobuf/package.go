@@ -27,11 +27,28 @@ func (p *Package) AddImport(s string) {
 	p.imports = append(p.imports, s)
 }
 
+// RemoveImport removes a package from the imports
+func (p *Package) RemoveImport(s string) {
+	var imports []string
+	for _, lib := range p.imports {
+		if lib != s {
+			imports = append(imports, lib)
+		}
+	}
+	p.imports = imports
+}
+
 // AddType adds a child type
 func (p *Package) AddType(t Type) {
 	p.children = append(p.children, t)
 }
 
+// SetComment sets the comment associated with this package, which
+// is written at the top of the encoded declaration
+func (p *Package) SetComment(s string) {
+	p.comment = s
+}
+
 // AddOption adds a global option
 func (p *Package) AddOption(t *GlobalOption) {
 	p.options = append(p.options, t)
@@ -50,7 +67,34 @@ func (o *GlobalOption) Name() string {
 	return o.name
 }
 
+// NewLiteralGlobalOption creates a GlobalOption whose value is written
+// as is instead of as a string, e.g. a number
+func NewLiteralGlobalOption(name, value string) *GlobalOption {
+	return &GlobalOption{
+		name:    name,
+		value:   value,
+		literal: true,
+	}
+}
+
+// NewAggregateGlobalOption creates a GlobalOption for an option whose
+// type is a message. The value is given as an aggregate, e.g.
+// `option (my.file_ext) = { a: 1 b: "x" };`. Values of the map may be
+// strings, numbers, booleans, maps, or lists of those for repeated fields
+func NewAggregateGlobalOption(name string, value map[string]interface{}) *GlobalOption {
+	return &GlobalOption{
+		name:      name,
+		aggregate: value,
+	}
+}
+
 // Value returns the value of the GlobalOption
 func (o *GlobalOption) Value() string {
 	return o.value
 }
+
+// Aggregate returns the value of the GlobalOption if it was created
+// by NewAggregateGlobalOption, or nil otherwise
+func (o *GlobalOption) Aggregate() map[string]interface{} {
+	return o.aggregate
+}
@@ -2,6 +2,10 @@ package protobuf_test
 
 import (
 	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/NYTimes/openapi2proto/protobuf"
@@ -90,3 +94,503 @@ service HelloWorldService {
 
 	t.Logf("%s", buf.String())
 }
+
+func TestEncode(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	m := protobuf.NewMessage("Hello")
+	m.AddField(protobuf.NewField(protobuf.StringType, "message", 1))
+	p.AddType(m)
+
+	var buf bytes.Buffer
+	if err := protobuf.NewEncoder(&buf).Encode(p); err != nil {
+		t.Errorf("failed to encode: %s", err)
+		return
+	}
+
+	b, err := protobuf.Encode(p)
+	if err != nil {
+		t.Errorf("failed to encode: %s", err)
+		return
+	}
+
+	if string(b) != buf.String() {
+		t.Errorf("protobuf.Encode output differs from Encoder.Encode:\n%s\n---\n%s", b, buf.String())
+	}
+}
+
+func TestBlankLinesBetweenMessages(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	m1 := protobuf.NewMessage("Hello")
+	m1.AddType(protobuf.NewMessage("Nested"))
+	m1.AddType(protobuf.NewMessage("Other"))
+	p.AddType(m1)
+	p.AddType(protobuf.NewMessage("World"))
+
+	b, err := protobuf.Encode(p, protobuf.WithBlankLinesBetweenMessages(2))
+	if err != nil {
+		t.Errorf("failed to encode: %s", err)
+		return
+	}
+
+	const expected = `syntax = "proto3";
+
+package helloworld;
+
+message Hello {
+    message Nested {}
+
+    message Other {}
+}
+
+
+message World {}`
+
+	if expected != string(b) {
+		t.Errorf("unexpected output:\n%s", b)
+	}
+
+	if _, err := protobuf.Encode(p, protobuf.WithBlankLinesBetweenMessages(-1)); err == nil {
+		t.Errorf("expected negative number of blank lines to fail")
+	}
+}
+
+func TestAggregateGlobalOption(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	p.AddOption(protobuf.NewAggregateGlobalOption("(acme.file_info)", map[string]interface{}{
+		"owner": "hello-team",
+		"retry": map[string]interface{}{"attempts": 3},
+	}))
+
+	b, err := protobuf.Encode(p)
+	if err != nil {
+		t.Fatalf("failed to encode: %s", err)
+	}
+
+	const expected = `option (acme.file_info) = {
+    owner: "hello-team"
+    retry: {
+        attempts: 3
+    }
+};`
+	if !bytes.Contains(b, []byte(expected)) {
+		t.Errorf("expected the option to be encoded as an aggregate, got:\n%s", b)
+	}
+
+	p = protobuf.NewPackage("helloworld")
+	p.AddOption(protobuf.NewAggregateGlobalOption("(acme.file_info)", map[string]interface{}{
+		"owner": nil,
+	}))
+	if _, err := protobuf.Encode(p); err == nil {
+		t.Errorf("expected a null value to fail")
+	}
+}
+
+func TestServiceRPCOrder(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	s := protobuf.NewService("HelloService")
+	for _, name := range []string{"Zebra", "Apple", "Mango"} {
+		s.AddRPC(protobuf.NewRPC(name))
+	}
+	p.AddType(s)
+
+	tests := map[string][]string{
+		"name":        {"Apple", "Mango", "Zebra"},
+		"declaration": {"Zebra", "Apple", "Mango"},
+	}
+	for order, names := range tests {
+		b, err := protobuf.Encode(p, protobuf.WithServiceRPCOrder(order))
+		if err != nil {
+			t.Errorf("failed to encode with order %s: %s", order, err)
+			continue
+		}
+
+		var last int
+		for _, name := range names {
+			i := bytes.Index(b, []byte("rpc "+name+"("))
+			if i < last {
+				t.Errorf("expected rpcs in the order %v with order %s, got:\n%s", names, order, b)
+				break
+			}
+			last = i
+		}
+	}
+
+	if _, err := protobuf.Encode(p, protobuf.WithServiceRPCOrder("random")); err == nil {
+		t.Errorf("expected unknown rpc order to fail")
+	}
+}
+
+func TestGeneratedHeader(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	p.AddType(protobuf.NewMessage("Hello"))
+
+	tests := []struct {
+		spec    string
+		version string
+		want    string
+	}{
+		{
+			spec: "specs/hello.yaml",
+			want: "// Code generated by openapi2proto from specs/hello.yaml. DO NOT EDIT.\n\nsyntax = \"proto3\";",
+		},
+		{
+			spec:    "hello.yaml",
+			version: "v1.2.0",
+			want:    "// Code generated by openapi2proto v1.2.0 from hello.yaml. DO NOT EDIT.\n\nsyntax = \"proto3\";",
+		},
+	}
+	for _, test := range tests {
+		b, err := protobuf.Encode(p, protobuf.WithGeneratedHeader(test.spec, test.version))
+		if err != nil {
+			t.Errorf("failed to encode: %s", err)
+			continue
+		}
+		if !bytes.HasPrefix(b, []byte(test.want)) {
+			t.Errorf("expected output to start with %q, got:\n%s", test.want, b)
+		}
+	}
+}
+
+func TestFieldOptions(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	m := protobuf.NewMessage("Hello")
+	f := protobuf.NewField(protobuf.StringType, "user_name", 1)
+	f.SetJSONName("user-name")
+	f.AddOption("deprecated", true)
+	f.AddOption("(validate.rules).string.min_len", 1)
+	m.AddField(f)
+	m.AddField(protobuf.NewField(protobuf.StringType, "message", 2))
+	p.AddType(m)
+
+	b, err := protobuf.Encode(p)
+	if err != nil {
+		t.Errorf("failed to encode: %s", err)
+		return
+	}
+
+	const expected = `syntax = "proto3";
+
+package helloworld;
+
+message Hello {
+    string user_name = 1 [json_name = "user-name", deprecated = true, (validate.rules).string.min_len = 1];
+    string message = 2;
+}`
+
+	if expected != string(b) {
+		t.Errorf("unexpected output:\n%s", b)
+	}
+}
+
+func TestEncodeToFS(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	p.AddImport("google/protobuf/empty.proto")
+	p.AddOption(protobuf.NewGlobalOption("go_package", "helloworld"))
+
+	hello := protobuf.NewMessage("HelloRequest")
+	hello.AddField(protobuf.NewField(protobuf.StringType, "message", 1))
+	p.AddType(hello)
+
+	world := protobuf.NewMessage("World")
+	world.AddField(protobuf.NewField(hello, "hello", 1))
+	p.AddType(world)
+
+	svc := protobuf.NewService("HelloService")
+	rpc := protobuf.NewRPC("HelloWorld")
+	rpc.SetParameter(hello)
+	svc.AddRPC(rpc)
+	p.AddType(svc)
+
+	dir, err := ioutil.TempDir("", "openapi2proto")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := protobuf.EncodeToFS(dir, p); err != nil {
+		t.Errorf("failed to encode: %s", err)
+		return
+	}
+
+	expected := map[string]string{
+		"hello_request.proto": `syntax = "proto3";
+
+package helloworld;
+
+import "google/protobuf/empty.proto";
+
+option go_package = "helloworld";
+
+message HelloRequest {
+    string message = 1;
+}`,
+		"world.proto": `syntax = "proto3";
+
+package helloworld;
+
+import "google/protobuf/empty.proto";
+import "hello_request.proto";
+
+option go_package = "helloworld";
+
+message World {
+    HelloRequest hello = 1;
+}`,
+		"service.proto": `syntax = "proto3";
+
+package helloworld;
+
+import "google/protobuf/empty.proto";
+import "hello_request.proto";
+
+option go_package = "helloworld";
+
+service HelloService {
+    rpc HelloWorld(HelloRequest) returns (google.protobuf.Empty) {}
+}`,
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %s", err)
+	}
+	if len(files) != len(expected) {
+		t.Errorf("expected %d files, got %d", len(expected), len(files))
+	}
+
+	for fn, want := range expected {
+		got, err := ioutil.ReadFile(filepath.Join(dir, fn))
+		if err != nil {
+			t.Errorf("failed to read %s: %s", fn, err)
+			continue
+		}
+		if want != string(got) {
+			t.Errorf("unexpected output for %s:\n%s", fn, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+
+	m := protobuf.NewMessage("Hello")
+	m.AddField(protobuf.NewField(protobuf.StringType, "message", 1))
+	m.AddField(protobuf.NewField(protobuf.StringType, "greeting", 1))
+	m.AddField(protobuf.NewField(protobuf.NewReference("#/definitions/World"), "world", 2))
+	p.AddType(m)
+
+	e1 := protobuf.NewEnum("Color")
+	e1.AddElement("RED")
+	e1.AddElement("not-a-name")
+	p.AddType(e1)
+
+	e2 := protobuf.NewEnum("Light")
+	e2.AddElement(protobuf.NewEnumElement("RED"))
+	p.AddType(e2)
+
+	p.AddType(protobuf.NewEnum("Empty"))
+
+	e3 := protobuf.NewEnum("Level")
+	one := protobuf.NewEnumElement("LEVEL_1")
+	one.SetNumber(1)
+	e3.AddElement(one)
+	p.AddType(e3)
+
+	want := []string{
+		"fields message and greeting in message Hello both use tag 1",
+		"field world in message Hello refers to #/definitions/World, which could not be resolved",
+		`enum Color has an illegal value name "not-a-name"`,
+		"value RED of enum Light conflicts with a value of enum Color",
+		"enum Empty has no values",
+		"the first value of enum Level must be zero",
+	}
+
+	errs := protobuf.Validate(p)
+	if len(errs) != len(want) {
+		t.Errorf("expected %d errors, got %d: %v", len(want), len(errs), errs)
+		return
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("expected error %q, got %q", want[i], err.Error())
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+
+	m1 := protobuf.NewMessage("Hello")
+	m1.AddField(protobuf.NewField(protobuf.StringType, "message", 1))
+	m2 := protobuf.NewMessage("World")
+	m2.AddField(protobuf.NewField(protobuf.Int32Type, "count", 1))
+	m1.AddType(m2)
+	p.AddType(m1)
+
+	m3 := protobuf.NewMessage("Skipped")
+	m3.AddField(protobuf.NewField(protobuf.StringType, "hidden", 1))
+	p.AddType(m3)
+
+	p.AddType(protobuf.NewEnum("Color"))
+	svc := protobuf.NewService("HelloWorldService")
+	svc.AddRPC(protobuf.NewRPC("SayHello"))
+	p.AddType(svc)
+
+	var visited []string
+	err := p.Walk(func(t protobuf.Node) error {
+		visited = append(visited, t.Name())
+		if f, ok := t.(*protobuf.Field); ok {
+			f.SetName(f.Name() + "_renamed")
+		}
+		if t.Name() == "Skipped" {
+			return protobuf.SkipChildren
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("failed to walk: %s", err)
+		return
+	}
+
+	want := []string{"Hello", "message", "World", "count", "Skipped", "Color", "HelloWorldService", "SayHello"}
+	if len(visited) != len(want) {
+		t.Errorf("expected to visit %v, got %v", want, visited)
+		return
+	}
+	for i := range want {
+		if visited[i] != want[i] {
+			t.Errorf("expected to visit %v, got %v", want, visited)
+			return
+		}
+	}
+
+	if name := m1.Fields()[0].Name(); name != "message_renamed" {
+		t.Errorf("expected field to be renamed, got %s", name)
+	}
+
+	stop := errors.New("stop")
+	var count int
+	err = p.Walk(func(t protobuf.Node) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("expected walk to stop at the first error, got %v after %d types", err, count)
+	}
+}
+
+func TestComments(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	m := protobuf.NewMessage("Hello")
+	m.SetComment("\nSays hello.  \n\nTo:\n  - the world\n  - everyone else\n\n")
+	f := protobuf.NewField(protobuf.StringType, "message", 1)
+	f.SetComment("The message.\r\n\r\nMay be empty.")
+	m.AddField(f)
+	p.AddType(m)
+
+	b, err := protobuf.Encode(p)
+	if err != nil {
+		t.Errorf("failed to encode: %s", err)
+		return
+	}
+
+	const expected = `syntax = "proto3";
+
+package helloworld;
+
+// Says hello.
+//
+// To:
+//   - the world
+//   - everyone else
+message Hello {
+    // The message.
+    //
+    // May be empty.
+    string message = 1;
+}`
+
+	if expected != string(b) {
+		t.Errorf("unexpected output:\n%s", b)
+	}
+}
+
+func TestEncodeToFile(t *testing.T) {
+	p := protobuf.NewPackage("helloworld")
+	hello := protobuf.NewMessage("Hello")
+	hello.AddField(protobuf.NewField(protobuf.StringType, "message", 1))
+	p.AddType(hello)
+	p.AddType(protobuf.NewMessage("World"))
+
+	dir, err := ioutil.TempDir("", "openapi2proto")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "helloworld.proto")
+	const existing = `syntax = "proto3";
+
+package helloworld;
+
+message Hello {
+    int32 outdated = 1;
+}
+
+// @openapi2proto:begin-custom Hello
+message HelloAudit {
+    Hello hello = 1;
+}
+// @openapi2proto:end-custom
+
+// @openapi2proto:begin-custom Removed
+message Kept {}
+// @openapi2proto:end-custom`
+	if err := ioutil.WriteFile(fn, []byte(existing), 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", fn, err)
+	}
+
+	const expected = `syntax = "proto3";
+
+package helloworld;
+
+message Hello {
+    string message = 1;
+}
+
+// @openapi2proto:begin-custom Hello
+message HelloAudit {
+    Hello hello = 1;
+}
+// @openapi2proto:end-custom
+
+message World {}
+
+// @openapi2proto:begin-custom Removed
+message Kept {}
+// @openapi2proto:end-custom`
+
+	// encoding again must not duplicate the regions
+	for i := 0; i < 2; i++ {
+		if err := protobuf.EncodeToFile(fn, p); err != nil {
+			t.Errorf("failed to encode: %s", err)
+			return
+		}
+
+		got, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", fn, err)
+		}
+		if expected != string(got) {
+			t.Errorf("unexpected output:\n%s", got)
+			return
+		}
+	}
+
+	if err := ioutil.WriteFile(fn, []byte("// @openapi2proto:begin-custom Hello\n"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", fn, err)
+	}
+	if err := protobuf.EncodeToFile(fn, p); err == nil {
+		t.Errorf("expected an unclosed custom region to fail")
+	}
+}
@@ -0,0 +1,105 @@
+package protobuf
+
+type pruneCtx struct {
+	// the top level messages and enums of the package, by the names
+	// of the types that they declare, including nested ones
+	declared map[string][]Type
+
+	// the top level types that can be reached so far
+	reachable map[Type]struct{}
+}
+
+// Prune removes the top level messages and enums of the package that
+// can not be reached from any of its services or extensions, or from
+// the types named in roots, either directly or through the fields of
+// other messages. Types nested within a reachable message are always
+// kept along with it.
+func Prune(p *Package, roots ...string) {
+	c := pruneCtx{
+		declared:  map[string][]Type{},
+		reachable: map[Type]struct{}{},
+	}
+
+	for _, child := range p.children {
+		switch child.(type) {
+		case *Message, *Enum:
+			c.declare(child, child)
+		}
+	}
+
+	for _, name := range roots {
+		c.mark(name)
+	}
+
+	for _, child := range p.children {
+		switch t := child.(type) {
+		case *Service:
+			for _, r := range t.rpcs {
+				c.markType(r.parameter)
+				c.markType(r.response)
+			}
+		case *Extension:
+			for _, f := range t.fields {
+				c.mark(f.typ)
+			}
+		}
+	}
+
+	var children []Type
+	for _, child := range p.children {
+		switch child.(type) {
+		case *Message, *Enum:
+			if _, ok := c.reachable[child]; !ok {
+				continue
+			}
+		}
+		children = append(children, child)
+	}
+	p.children = children
+}
+
+func (c *pruneCtx) declare(t, top Type) {
+	c.declared[t.Name()] = append(c.declared[t.Name()], top)
+	if m, ok := t.(*Message); ok {
+		for _, child := range m.children {
+			c.declare(child, top)
+		}
+	}
+}
+
+func (c *pruneCtx) markType(t Type) {
+	switch t := t.(type) {
+	case nil:
+	case *Map:
+		c.markType(t.key)
+		c.markType(t.value)
+	default:
+		// types are looked up by name, as messages are sometimes
+		// created only to refer to another message with the same name
+		c.mark(t.Name())
+	}
+}
+
+func (c *pruneCtx) mark(name string) {
+	for _, t := range c.declared[name] {
+		if _, ok := c.reachable[t]; ok {
+			continue
+		}
+		c.reachable[t] = struct{}{}
+
+		if m, ok := t.(*Message); ok {
+			c.markMessage(m)
+		}
+	}
+}
+
+func (c *pruneCtx) markMessage(m *Message) {
+	for _, f := range m.fields {
+		c.markType(f.typ)
+	}
+	for _, child := range m.children {
+		if nested, ok := child.(*Message); ok {
+			c.markMessage(nested)
+		}
+	}
+}
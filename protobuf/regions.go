@@ -0,0 +1,155 @@
+package protobuf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// markers of the custom regions that are kept when a file is generated
+// again. The name that follows the begin marker is the name of the top
+// level declaration that the region is placed after
+const (
+	beginCustomRegion = "// @openapi2proto:begin-custom"
+	endCustomRegion   = "// @openapi2proto:end-custom"
+)
+
+var topLevelDeclaration = regexp.MustCompile(`^(?:message|enum|service|extend) (\S+) \{`)
+
+type customRegion struct {
+	name  string
+	lines []string
+}
+
+// EncodeToFile encodes the package into the file `fn`. If the file
+// already exists, the custom regions found in it are kept: each block
+// of lines between `// @openapi2proto:begin-custom X` and
+// `// @openapi2proto:end-custom` is copied as is after the top level
+// declaration named X in the new content, e.g. to add hand written
+// messages or options that would otherwise be lost when regenerating.
+// Regions whose declaration no longer exists are kept at the end.
+func EncodeToFile(fn string, p *Package, options ...Option) error {
+	generated, err := Encode(p, options...)
+	if err != nil {
+		return errors.Wrap(err, `failed to encode package`)
+	}
+
+	existing, err := ioutil.ReadFile(fn)
+	switch {
+	case err == nil:
+		generated, err = KeepCustomRegions(generated, existing)
+		if err != nil {
+			return errors.Wrapf(err, `failed to keep custom regions of %s`, fn)
+		}
+	case !os.IsNotExist(err):
+		return errors.Wrapf(err, `failed to read %s`, fn)
+	}
+
+	if err := ioutil.WriteFile(fn, generated, 0644); err != nil {
+		return errors.Wrapf(err, `failed to write %s`, fn)
+	}
+	return nil
+}
+
+// KeepCustomRegions copies the custom regions found in `existing` into
+// the `generated` declaration, as described in EncodeToFile.
+func KeepCustomRegions(generated, existing []byte) ([]byte, error) {
+	regions, err := extractCustomRegions(string(existing))
+	if err != nil {
+		return nil, err
+	}
+	if len(regions) == 0 {
+		return generated, nil
+	}
+
+	byName := map[string][]customRegion{}
+	for _, r := range regions {
+		byName[r.name] = append(byName[r.name], r)
+	}
+
+	var buf bytes.Buffer
+	writeRegions := func(regions []customRegion) {
+		for _, r := range regions {
+			buf.WriteString("\n\n")
+			buf.WriteString(strings.Join(r.lines, "\n"))
+		}
+	}
+
+	// the name of the declaration whose closing brace we are looking for
+	var open string
+	lines := strings.Split(string(generated), "\n")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+
+		if open == "" {
+			m := topLevelDeclaration.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if !strings.HasSuffix(line, "{}") {
+				open = m[1]
+				continue
+			}
+			writeRegions(byName[m[1]])
+			delete(byName, m[1])
+			continue
+		}
+
+		if line == "}" {
+			writeRegions(byName[open])
+			delete(byName, open)
+			open = ""
+		}
+	}
+
+	// keep the regions of declarations that are gone, in their
+	// original order, so that nothing is lost
+	for _, r := range regions {
+		if _, ok := byName[r.name]; ok {
+			writeRegions(byName[r.name])
+			delete(byName, r.name)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func extractCustomRegions(src string) ([]customRegion, error) {
+	var regions []customRegion
+	var current *customRegion
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, beginCustomRegion):
+			if current != nil {
+				return nil, errors.Errorf(`line %d: custom region %s is not closed`, i+1, current.name)
+			}
+			name := strings.TrimSpace(strings.TrimPrefix(trimmed, beginCustomRegion))
+			if name == "" {
+				return nil, errors.Errorf(`line %d: custom region without a name`, i+1)
+			}
+			current = &customRegion{name: name, lines: []string{line}}
+		case strings.HasPrefix(trimmed, endCustomRegion):
+			if current == nil {
+				return nil, errors.Errorf(`line %d: end of a custom region that was not started`, i+1)
+			}
+			current.lines = append(current.lines, line)
+			regions = append(regions, *current)
+			current = nil
+		case current != nil:
+			current.lines = append(current.lines, line)
+		}
+	}
+
+	if current != nil {
+		return nil, errors.Errorf(`custom region %s is not closed`, current.name)
+	}
+	return regions, nil
+}
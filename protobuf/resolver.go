@@ -66,9 +66,11 @@ func (c *resolveCtx) resolve(t Type) (Type, error) {
 		p.children = children
 		return &p, nil
 	case *Message:
+		// messages are resolved in place, as fields elsewhere
+		// may already be referring to them
 		c.push(t)
 		defer c.pop()
-		m := *t
+		m := t
 		children, err := c.resolveChildren(m.children)
 		if err != nil {
 			return nil, errors.Wrap(err, `failed to resolve children`)
@@ -84,15 +86,20 @@ func (c *resolveCtx) resolve(t Type) (Type, error) {
 				}
 				f.typ = t2
 			case *Map:
-				t2, err := c.resolve(typ.value)
-				if err != nil {
-					return nil, errors.Wrapf(err, `failed to resolve map field type %s`, typ.value.Name())
+				// only references need resolving. messages used as
+				// values are resolved where they are declared, and
+				// may contain the map themselves
+				if ref, ok := typ.value.(*Reference); ok {
+					t2, err := c.resolveFunc(ref.Name())
+					if err != nil {
+						return nil, errors.Wrapf(err, `failed to resolve map field type %s`, ref.Name())
+					}
+					typ.value = t2
 				}
-				typ.value = t2
 			}
 		}
 
-		return &m, nil
+		return m, nil
 	default:
 		return t, nil
 	}
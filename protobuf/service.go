@@ -20,4 +20,9 @@ func (s *Service) Name() string {
 // AddRPC associates an RPC object to this service
 func (s *Service) AddRPC(r *RPC) {
 	s.rpcs = append(s.rpcs, r)
+}
+
+// RPCs returns the RPC objects associated with this service
+func (s *Service) RPCs() []*RPC {
+	return s.rpcs
 }
\ No newline at end of file
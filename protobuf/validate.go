@@ -0,0 +1,137 @@
+package protobuf
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxFieldNumber           = 536870911
+	firstReservedFieldNumber = 19000
+	lastReservedFieldNumber  = 19999
+)
+
+var identifierRx = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type validateCtx struct {
+	errors []error
+}
+
+// Validate checks the package for problems that would make the
+// encoded declaration invalid, such as duplicate field numbers, unresolved
+// references, illegal enum value names, or enums without any values.
+// All problems found are returned, in the order they were encountered.
+func Validate(p *Package) []error {
+	var c validateCtx
+	c.validateChildren("", p.children)
+	return c.errors
+}
+
+func (c *validateCtx) errorf(f string, args ...interface{}) {
+	c.errors = append(c.errors, errors.Errorf(f, args...))
+}
+
+func qualifiedName(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (c *validateCtx) validateChildren(scope string, children []Type) {
+	// enum values are scoped to the enclosing declaration, not the enum,
+	// so values of sibling enums may not share a name
+	values := map[string]string{}
+	for _, child := range children {
+		switch t := child.(type) {
+		case *Message:
+			c.validateMessage(scope, t)
+		case *Enum:
+			c.validateEnum(scope, t, values)
+		case *Service:
+			c.validateService(t)
+		}
+	}
+}
+
+func (c *validateCtx) validateMessage(scope string, m *Message) {
+	name := qualifiedName(scope, m.name)
+
+	names := map[string]struct{}{}
+	numbers := map[int]string{}
+	for _, f := range m.fields {
+		if _, ok := names[f.name]; ok {
+			c.errorf(`message %s has more than one field named %s`, name, f.name)
+		}
+		names[f.name] = struct{}{}
+
+		if other, ok := numbers[f.index]; ok {
+			c.errorf(`fields %s and %s in message %s both use tag %d`, other, f.name, name, f.index)
+		}
+		numbers[f.index] = f.name
+
+		if f.index < 1 || f.index > maxFieldNumber {
+			c.errorf(`field %s in message %s uses tag %d, which is out of range`, f.name, name, f.index)
+		} else if f.index >= firstReservedFieldNumber && f.index <= lastReservedFieldNumber {
+			c.errorf(`field %s in message %s uses tag %d, which is reserved`, f.name, name, f.index)
+		}
+
+		typ := f.typ
+		if mt, ok := typ.(*Map); ok {
+			typ = mt.value
+		}
+		if ref, ok := typ.(*Reference); ok {
+			c.errorf(`field %s in message %s refers to %s, which could not be resolved`, f.name, name, ref.name)
+		}
+	}
+
+	c.validateChildren(name, m.children)
+}
+
+func (c *validateCtx) validateEnum(scope string, e *Enum, values map[string]string) {
+	name := qualifiedName(scope, e.name)
+
+	// proto3 requires the first value of an enum to be zero, which
+	// can't be satisfied by an enum without values
+	if len(e.elements) == 0 {
+		c.errorf(`enum %s has no values`, name)
+	} else if ee, ok := e.elements[0].(*EnumElement); ok && ee.explicit && ee.number != 0 {
+		c.errorf(`the first value of enum %s must be zero`, name)
+	}
+
+	for _, elem := range e.elements {
+		var vname string
+		if ee, ok := elem.(*EnumElement); ok {
+			vname = ee.name
+		} else {
+			vname = fmt.Sprintf("%s", elem)
+		}
+
+		if !identifierRx.MatchString(vname) {
+			c.errorf(`enum %s has an illegal value name %q`, name, vname)
+			continue
+		}
+
+		if other, ok := values[vname]; ok {
+			if other == e.name {
+				c.errorf(`enum %s has more than one value named %s`, name, vname)
+			} else {
+				c.errorf(`value %s of enum %s conflicts with a value of enum %s`, vname, name, qualifiedName(scope, other))
+			}
+			continue
+		}
+		values[vname] = e.name
+	}
+}
+
+func (c *validateCtx) validateService(s *Service) {
+	for _, r := range s.rpcs {
+		for _, t := range []Type{r.parameter, r.response} {
+			if ref, ok := t.(*Reference); ok {
+				c.errorf(`rpc %s in service %s refers to %s, which could not be resolved`, r.name, s.name, ref.name)
+			}
+		}
+	}
+}
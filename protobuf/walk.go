@@ -0,0 +1,57 @@
+package protobuf
+
+import "github.com/pkg/errors"
+
+// SkipChildren is used as a return value from the function passed to
+// Walk, to indicate that the fields and nested types of the message,
+// or the rpcs of the service that was just visited should be skipped.
+// It is not returned as an error by Walk
+var SkipChildren = errors.New("skip children")
+
+// Walk calls fn for each type declared in the package, in the order
+// in which they were added. Messages are followed by their fields, and
+// then by the types nested within them, so fn sees a message before
+// anything it contains. Services are followed by their rpcs, and
+// extensions are visited as well.
+//
+// This can be used to modify the package, e.g. to rename fields, after
+// compiling a spec and before encoding it. If fn returns an error other
+// than SkipChildren, the walk stops and the error is returned
+func (p *Package) Walk(fn func(Node) error) error {
+	return walkTypes(p.children, fn)
+}
+
+func walkTypes(types []Type, fn func(Node) error) error {
+	for _, t := range types {
+		if err := walk(t, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walk(t Type, fn func(Node) error) error {
+	if err := fn(t); err != nil {
+		if err == SkipChildren {
+			return nil
+		}
+		return err
+	}
+
+	switch t := t.(type) {
+	case *Message:
+		for _, f := range t.fields {
+			if err := fn(f); err != nil && err != SkipChildren {
+				return err
+			}
+		}
+		return walkTypes(t.children, fn)
+	case *Service:
+		for _, r := range t.rpcs {
+			if err := fn(r); err != nil && err != SkipChildren {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -19,19 +19,23 @@ import (
 // For more control, use `openapi`, `compiler`, and `protobuf`
 // packages directly.
 func Transpile(dst io.Writer, srcFn string, options ...Option) error {
-	var encoderOptions []protobuf.Option
-	var compilerOptions []compiler.Option
+	compilerOptions, encoderOptions := splitOptions(options)
 
-	for _, o := range options {
-		switch o.Name() {
-		case optkeyEncoderOptions:
-			encoderOptions = o.Value().([]protobuf.Option)
-		case optkeyCompilerOptions:
-			compilerOptions = o.Value().([]compiler.Option)
-		}
+	p, err := compiler.CompileFile(srcFn, compilerOptions...)
+	if err != nil {
+		return err
 	}
 
-	s, err := openapi.LoadFile(srcFn)
+	return encode(dst, p, encoderOptions)
+}
+
+// TranspileReader is the same as Transpile, but reads the OpenAPI
+// spec from `src` instead of a file. `format` specifies how the
+// content should be decoded, and must be either "yaml" or "json".
+func TranspileReader(dst io.Writer, src io.Reader, format string, options ...Option) error {
+	compilerOptions, encoderOptions := splitOptions(options)
+
+	s, err := openapi.LoadReader(src, format)
 	if err != nil {
 		return errors.Wrap(err, `failed to load OpenAPI spec`)
 	}
@@ -41,7 +45,26 @@ func Transpile(dst io.Writer, srcFn string, options ...Option) error {
 		return errors.Wrap(err, `failed to compile OpenAPI spec to Protocol buffers`)
 	}
 
-	if err := protobuf.NewEncoder(dst, encoderOptions...).Encode(p); err != nil {
+	return encode(dst, p, encoderOptions)
+}
+
+func splitOptions(options []Option) ([]compiler.Option, []protobuf.Option) {
+	var encoderOptions []protobuf.Option
+	var compilerOptions []compiler.Option
+
+	for _, o := range options {
+		switch o.Name() {
+		case optkeyEncoderOptions:
+			encoderOptions = o.Value().([]protobuf.Option)
+		case optkeyCompilerOptions:
+			compilerOptions = o.Value().([]compiler.Option)
+		}
+	}
+	return compilerOptions, encoderOptions
+}
+
+func encode(dst io.Writer, p *protobuf.Package, options []protobuf.Option) error {
+	if err := protobuf.NewEncoder(dst, options...).Encode(p); err != nil {
 		return errors.Wrap(err, `failed to encode protocol buffers to text`)
 	}
 